@@ -15,7 +15,11 @@
 package internal
 
 import (
+	"errors"
+	"fmt"
+	"strconv"
 	"strings"
+	"sync/atomic"
 )
 
 const (
@@ -23,32 +27,469 @@ const (
 	MultiState
 	SubscribeState
 	MonitorState
+	ShardSubscribeState
 )
 
+const (
+	WriteFlag = 1 << iota
+	ReadOnlyFlag
+	BlockingFlag
+	AdminFlag
+	PubSubFlag
+	TransactionFlag
+	MovableKeysFlag
+)
+
+// CommandInfo describes a command. Set and Clear are the connection state
+// bits changed by the command. Arity is the number of arguments including the
+// command name; a negative arity is the minimum number of arguments.
+// FirstKey, LastKey and Step are the positions of the keys, where the command
+// name is at position 0 and a negative LastKey counts from the end.
 type CommandInfo struct {
-	Set, Clear int
+	Set, Clear              int
+	Arity                   int
+	Flags                   int
+	FirstKey, LastKey, Step int
 }
 
-var commandInfos = map[string]CommandInfo{
-	"WATCH":      {Set: WatchState},
-	"UNWATCH":    {Clear: WatchState},
-	"MULTI":      {Set: MultiState},
-	"EXEC":       {Clear: WatchState | MultiState},
-	"DISCARD":    {Clear: WatchState | MultiState},
-	"PSUBSCRIBE": {Set: SubscribeState},
-	"SUBSCRIBE":  {Set: SubscribeState},
-	"MONITOR":    {Set: MonitorState},
+var builtinCommandInfos = map[string]CommandInfo{
+	"APPEND":               {Arity: 3, Flags: WriteFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"DECR":                 {Arity: 2, Flags: WriteFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"DECRBY":               {Arity: 3, Flags: WriteFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"GET":                  {Arity: 2, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"GETDEL":               {Arity: 2, Flags: WriteFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"GETEX":                {Arity: -2, Flags: WriteFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"GETRANGE":             {Arity: 4, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"GETSET":               {Arity: 3, Flags: WriteFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"INCR":                 {Arity: 2, Flags: WriteFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"INCRBY":               {Arity: 3, Flags: WriteFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"INCRBYFLOAT":          {Arity: 3, Flags: WriteFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"LCS":                  {Arity: -3, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: 2, Step: 1},
+	"MGET":                 {Arity: -2, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: -1, Step: 1},
+	"MSET":                 {Arity: -3, Flags: WriteFlag, FirstKey: 1, LastKey: -1, Step: 2},
+	"MSETNX":               {Arity: -3, Flags: WriteFlag, FirstKey: 1, LastKey: -1, Step: 2},
+	"PSETEX":               {Arity: 4, Flags: WriteFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"SET":                  {Arity: -3, Flags: WriteFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"SETEX":                {Arity: 4, Flags: WriteFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"SETNX":                {Arity: 3, Flags: WriteFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"SETRANGE":             {Arity: 4, Flags: WriteFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"STRLEN":               {Arity: 2, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"SUBSTR":               {Arity: 4, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"BITCOUNT":             {Arity: -2, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"BITFIELD":             {Arity: -2, Flags: WriteFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"BITFIELD_RO":          {Arity: -2, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"BITOP":                {Arity: -4, Flags: WriteFlag, FirstKey: 2, LastKey: -1, Step: 1},
+	"BITPOS":               {Arity: -3, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"GETBIT":               {Arity: 3, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"SETBIT":               {Arity: 4, Flags: WriteFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"PFADD":                {Arity: -2, Flags: WriteFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"PFCOUNT":              {Arity: -2, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: -1, Step: 1},
+	"PFMERGE":              {Arity: -2, Flags: WriteFlag, FirstKey: 1, LastKey: -1, Step: 1},
+	"COPY":                 {Arity: -3, Flags: WriteFlag, FirstKey: 1, LastKey: 2, Step: 1},
+	"DEL":                  {Arity: -2, Flags: WriteFlag, FirstKey: 1, LastKey: -1, Step: 1},
+	"DUMP":                 {Arity: 2, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"EXISTS":               {Arity: -2, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: -1, Step: 1},
+	"EXPIRE":               {Arity: -3, Flags: WriteFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"EXPIREAT":             {Arity: -3, Flags: WriteFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"EXPIRETIME":           {Arity: 2, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"KEYS":                 {Arity: 2, Flags: ReadOnlyFlag},
+	"MIGRATE":              {Arity: -6, Flags: WriteFlag | MovableKeysFlag, FirstKey: 3, LastKey: 3, Step: 1},
+	"MOVE":                 {Arity: 3, Flags: WriteFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"OBJECT":               {Arity: -2, Flags: ReadOnlyFlag, FirstKey: 2, LastKey: 2, Step: 1},
+	"PERSIST":              {Arity: 2, Flags: WriteFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"PEXPIRE":              {Arity: -3, Flags: WriteFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"PEXPIREAT":            {Arity: -3, Flags: WriteFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"PEXPIRETIME":          {Arity: 2, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"PTTL":                 {Arity: 2, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"RANDOMKEY":            {Arity: 1, Flags: ReadOnlyFlag},
+	"RENAME":               {Arity: 3, Flags: WriteFlag, FirstKey: 1, LastKey: 2, Step: 1},
+	"RENAMENX":             {Arity: 3, Flags: WriteFlag, FirstKey: 1, LastKey: 2, Step: 1},
+	"RESTORE":              {Arity: -4, Flags: WriteFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"SCAN":                 {Arity: -2, Flags: ReadOnlyFlag},
+	"SORT":                 {Arity: -2, Flags: WriteFlag | MovableKeysFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"SORT_RO":              {Arity: -2, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"TOUCH":                {Arity: -2, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: -1, Step: 1},
+	"TTL":                  {Arity: 2, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"TYPE":                 {Arity: 2, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"UNLINK":               {Arity: -2, Flags: WriteFlag, FirstKey: 1, LastKey: -1, Step: 1},
+	"WAIT":                 {Arity: 3, Flags: BlockingFlag},
+	"WAITAOF":              {Arity: 4, Flags: BlockingFlag},
+	"BLMOVE":               {Arity: 6, Flags: WriteFlag | BlockingFlag, FirstKey: 1, LastKey: 2, Step: 1},
+	"BLMPOP":               {Arity: -5, Flags: WriteFlag | BlockingFlag | MovableKeysFlag},
+	"BLPOP":                {Arity: -3, Flags: WriteFlag | BlockingFlag, FirstKey: 1, LastKey: -2, Step: 1},
+	"BRPOP":                {Arity: -3, Flags: WriteFlag | BlockingFlag, FirstKey: 1, LastKey: -2, Step: 1},
+	"BRPOPLPUSH":           {Arity: 4, Flags: WriteFlag | BlockingFlag, FirstKey: 1, LastKey: 2, Step: 1},
+	"LINDEX":               {Arity: 3, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"LINSERT":              {Arity: 5, Flags: WriteFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"LLEN":                 {Arity: 2, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"LMOVE":                {Arity: 5, Flags: WriteFlag, FirstKey: 1, LastKey: 2, Step: 1},
+	"LMPOP":                {Arity: -4, Flags: WriteFlag | MovableKeysFlag},
+	"LPOP":                 {Arity: -2, Flags: WriteFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"LPOS":                 {Arity: -3, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"LPUSH":                {Arity: -3, Flags: WriteFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"LPUSHX":               {Arity: -3, Flags: WriteFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"LRANGE":               {Arity: 4, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"LREM":                 {Arity: 4, Flags: WriteFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"LSET":                 {Arity: 4, Flags: WriteFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"LTRIM":                {Arity: 4, Flags: WriteFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"RPOP":                 {Arity: -2, Flags: WriteFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"RPOPLPUSH":            {Arity: 3, Flags: WriteFlag, FirstKey: 1, LastKey: 2, Step: 1},
+	"RPUSH":                {Arity: -3, Flags: WriteFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"RPUSHX":               {Arity: -3, Flags: WriteFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"HDEL":                 {Arity: -3, Flags: WriteFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"HEXISTS":              {Arity: 3, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"HGET":                 {Arity: 3, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"HGETALL":              {Arity: 2, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"HINCRBY":              {Arity: 4, Flags: WriteFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"HINCRBYFLOAT":         {Arity: 4, Flags: WriteFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"HKEYS":                {Arity: 2, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"HLEN":                 {Arity: 2, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"HMGET":                {Arity: -3, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"HMSET":                {Arity: -4, Flags: WriteFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"HRANDFIELD":           {Arity: -2, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"HSCAN":                {Arity: -3, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"HSET":                 {Arity: -4, Flags: WriteFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"HSETNX":               {Arity: 4, Flags: WriteFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"HSTRLEN":              {Arity: 3, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"HVALS":                {Arity: 2, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"SADD":                 {Arity: -3, Flags: WriteFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"SCARD":                {Arity: 2, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"SDIFF":                {Arity: -2, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: -1, Step: 1},
+	"SDIFFSTORE":           {Arity: -3, Flags: WriteFlag, FirstKey: 1, LastKey: -1, Step: 1},
+	"SINTER":               {Arity: -2, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: -1, Step: 1},
+	"SINTERCARD":           {Arity: -3, Flags: ReadOnlyFlag | MovableKeysFlag},
+	"SINTERSTORE":          {Arity: -3, Flags: WriteFlag, FirstKey: 1, LastKey: -1, Step: 1},
+	"SISMEMBER":            {Arity: 3, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"SMEMBERS":             {Arity: 2, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"SMISMEMBER":           {Arity: -3, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"SMOVE":                {Arity: 4, Flags: WriteFlag, FirstKey: 1, LastKey: 2, Step: 1},
+	"SPOP":                 {Arity: -2, Flags: WriteFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"SRANDMEMBER":          {Arity: -2, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"SREM":                 {Arity: -3, Flags: WriteFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"SSCAN":                {Arity: -3, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"SUNION":               {Arity: -2, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: -1, Step: 1},
+	"SUNIONSTORE":          {Arity: -3, Flags: WriteFlag, FirstKey: 1, LastKey: -1, Step: 1},
+	"BZMPOP":               {Arity: -5, Flags: WriteFlag | BlockingFlag | MovableKeysFlag},
+	"BZPOPMAX":             {Arity: -3, Flags: WriteFlag | BlockingFlag, FirstKey: 1, LastKey: -2, Step: 1},
+	"BZPOPMIN":             {Arity: -3, Flags: WriteFlag | BlockingFlag, FirstKey: 1, LastKey: -2, Step: 1},
+	"ZADD":                 {Arity: -4, Flags: WriteFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"ZCARD":                {Arity: 2, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"ZCOUNT":               {Arity: 4, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"ZDIFF":                {Arity: -3, Flags: ReadOnlyFlag | MovableKeysFlag},
+	"ZDIFFSTORE":           {Arity: -4, Flags: WriteFlag | MovableKeysFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"ZINCRBY":              {Arity: 4, Flags: WriteFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"ZINTER":               {Arity: -3, Flags: ReadOnlyFlag | MovableKeysFlag},
+	"ZINTERCARD":           {Arity: -3, Flags: ReadOnlyFlag | MovableKeysFlag},
+	"ZINTERSTORE":          {Arity: -4, Flags: WriteFlag | MovableKeysFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"ZLEXCOUNT":            {Arity: 4, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"ZMPOP":                {Arity: -4, Flags: WriteFlag | MovableKeysFlag},
+	"ZMSCORE":              {Arity: -3, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"ZPOPMAX":              {Arity: -2, Flags: WriteFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"ZPOPMIN":              {Arity: -2, Flags: WriteFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"ZRANDMEMBER":          {Arity: -2, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"ZRANGE":               {Arity: -4, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"ZRANGEBYLEX":          {Arity: -4, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"ZRANGEBYSCORE":        {Arity: -4, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"ZRANGESTORE":          {Arity: -5, Flags: WriteFlag, FirstKey: 1, LastKey: 2, Step: 1},
+	"ZRANK":                {Arity: -3, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"ZREM":                 {Arity: -3, Flags: WriteFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"ZREMRANGEBYLEX":       {Arity: 4, Flags: WriteFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"ZREMRANGEBYRANK":      {Arity: 4, Flags: WriteFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"ZREMRANGEBYSCORE":     {Arity: 4, Flags: WriteFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"ZREVRANGE":            {Arity: -4, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"ZREVRANGEBYLEX":       {Arity: -4, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"ZREVRANGEBYSCORE":     {Arity: -4, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"ZREVRANK":             {Arity: -3, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"ZSCAN":                {Arity: -3, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"ZSCORE":               {Arity: 3, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"ZUNION":               {Arity: -3, Flags: ReadOnlyFlag | MovableKeysFlag},
+	"ZUNIONSTORE":          {Arity: -4, Flags: WriteFlag | MovableKeysFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"XACK":                 {Arity: -4, Flags: WriteFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"XADD":                 {Arity: -5, Flags: WriteFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"XAUTOCLAIM":           {Arity: -6, Flags: WriteFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"XCLAIM":               {Arity: -6, Flags: WriteFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"XDEL":                 {Arity: -3, Flags: WriteFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"XGROUP":               {Arity: -2, Flags: WriteFlag, FirstKey: 2, LastKey: 2, Step: 1},
+	"XINFO":                {Arity: -2, Flags: ReadOnlyFlag, FirstKey: 2, LastKey: 2, Step: 1},
+	"XLEN":                 {Arity: 2, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"XPENDING":             {Arity: -3, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"XRANGE":               {Arity: -4, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"XREAD":                {Arity: -4, Flags: ReadOnlyFlag | BlockingFlag | MovableKeysFlag},
+	"XREADGROUP":           {Arity: -7, Flags: WriteFlag | BlockingFlag | MovableKeysFlag},
+	"XREVRANGE":            {Arity: -4, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"XSETID":               {Arity: -3, Flags: WriteFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"XTRIM":                {Arity: -4, Flags: WriteFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"GEOADD":               {Arity: -5, Flags: WriteFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"GEODIST":              {Arity: -4, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"GEOHASH":              {Arity: -2, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"GEOPOS":               {Arity: -2, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"GEORADIUS":            {Arity: -6, Flags: WriteFlag | MovableKeysFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"GEORADIUSBYMEMBER":    {Arity: -5, Flags: WriteFlag | MovableKeysFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"GEORADIUSBYMEMBER_RO": {Arity: -5, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"GEORADIUS_RO":         {Arity: -6, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"GEOSEARCH":            {Arity: -7, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"GEOSEARCHSTORE":       {Arity: -8, Flags: WriteFlag, FirstKey: 1, LastKey: 2, Step: 1},
+	"PSUBSCRIBE":           {Set: SubscribeState, Arity: -2, Flags: PubSubFlag},
+	"PUBLISH":              {Arity: 3, Flags: PubSubFlag},
+	"PUBSUB":               {Arity: -2, Flags: PubSubFlag},
+	"PUNSUBSCRIBE":         {Arity: -1, Flags: PubSubFlag},
+	"SPUBLISH":             {Arity: 3, Flags: PubSubFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	"SSUBSCRIBE":           {Set: ShardSubscribeState, Arity: -2, Flags: PubSubFlag, FirstKey: 1, LastKey: -1, Step: 1},
+	"SUBSCRIBE":            {Set: SubscribeState, Arity: -2, Flags: PubSubFlag},
+	"SUNSUBSCRIBE":         {Arity: -1, Flags: PubSubFlag, FirstKey: 1, LastKey: -1, Step: 1},
+	"UNSUBSCRIBE":          {Arity: -1, Flags: PubSubFlag},
+	"DISCARD":              {Clear: WatchState | MultiState, Arity: 1, Flags: TransactionFlag},
+	"EXEC":                 {Clear: WatchState | MultiState, Arity: 1, Flags: TransactionFlag},
+	"MULTI":                {Set: MultiState, Arity: 1, Flags: TransactionFlag},
+	"UNWATCH":              {Clear: WatchState, Arity: 1, Flags: TransactionFlag},
+	"WATCH":                {Set: WatchState, Arity: -2, Flags: TransactionFlag, FirstKey: 1, LastKey: -1, Step: 1},
+	"EVAL":                 {Arity: -3, Flags: WriteFlag | MovableKeysFlag},
+	"EVALSHA":              {Arity: -3, Flags: WriteFlag | MovableKeysFlag},
+	"EVALSHA_RO":           {Arity: -3, Flags: ReadOnlyFlag | MovableKeysFlag},
+	"EVAL_RO":              {Arity: -3, Flags: ReadOnlyFlag | MovableKeysFlag},
+	"FCALL":                {Arity: -3, Flags: WriteFlag | MovableKeysFlag},
+	"FCALL_RO":             {Arity: -3, Flags: ReadOnlyFlag | MovableKeysFlag},
+	"FUNCTION":             {Arity: -2},
+	"SCRIPT":               {Arity: -2},
+	"AUTH":                 {Arity: -2},
+	"CLIENT":               {Arity: -2, Flags: AdminFlag},
+	"ECHO":                 {Arity: 2},
+	"HELLO":                {Arity: -1},
+	"PING":                 {Arity: -1},
+	"QUIT":                 {Arity: -1},
+	"RESET":                {Arity: 1},
+	"SELECT":               {Arity: 2},
+	"ACL":                  {Arity: -2, Flags: AdminFlag},
+	"BGREWRITEAOF":         {Arity: 1, Flags: AdminFlag},
+	"BGSAVE":               {Arity: -1, Flags: AdminFlag},
+	"COMMAND":              {Arity: -1},
+	"CONFIG":               {Arity: -2, Flags: AdminFlag},
+	"DBSIZE":               {Arity: 1, Flags: ReadOnlyFlag},
+	"DEBUG":                {Arity: -2, Flags: AdminFlag},
+	"FAILOVER":             {Arity: -1, Flags: AdminFlag},
+	"FLUSHALL":             {Arity: -1, Flags: WriteFlag},
+	"FLUSHDB":              {Arity: -1, Flags: WriteFlag},
+	"INFO":                 {Arity: -1},
+	"LASTSAVE":             {Arity: 1},
+	"LATENCY":              {Arity: -2, Flags: AdminFlag},
+	"LOLWUT":               {Arity: -1, Flags: ReadOnlyFlag},
+	"MEMORY":               {Arity: -2},
+	"MODULE":               {Arity: -2, Flags: AdminFlag},
+	"MONITOR":              {Set: MonitorState, Arity: 1, Flags: AdminFlag},
+	"PSYNC":                {Arity: -3, Flags: AdminFlag},
+	"REPLCONF":             {Arity: -1, Flags: AdminFlag},
+	"REPLICAOF":            {Arity: 3, Flags: AdminFlag},
+	"ROLE":                 {Arity: 1},
+	"SAVE":                 {Arity: 1, Flags: AdminFlag},
+	"SHUTDOWN":             {Arity: -1, Flags: AdminFlag},
+	"SLAVEOF":              {Arity: 3, Flags: AdminFlag},
+	"SLOWLOG":              {Arity: -2, Flags: AdminFlag},
+	"SWAPDB":               {Arity: 3, Flags: WriteFlag},
+	"SYNC":                 {Arity: 1, Flags: AdminFlag},
+	"TIME":                 {Arity: 1},
+	"ASKING":               {Arity: 1},
+	"CLUSTER":              {Arity: -2},
+	"READONLY":             {Arity: 1},
+	"READWRITE":            {Arity: 1},
+	"SENTINEL":             {Arity: -2, Flags: AdminFlag},
 }
 
+// commandInfos holds the current map[string]CommandInfo.
+var commandInfos atomic.Value
+
 func init() {
-	for n, ci := range commandInfos {
-		commandInfos[strings.ToLower(n)] = ci
+	commandInfos.Store(newCommandInfos(nil))
+}
+
+func newCommandInfos(loaded map[string]CommandInfo) map[string]CommandInfo {
+	m := make(map[string]CommandInfo, 2*(len(builtinCommandInfos)+len(loaded)))
+	add := func(n string, ci CommandInfo) {
+		m[n] = ci
+		m[strings.ToLower(n)] = ci
+	}
+	for n, ci := range builtinCommandInfos {
+		add(n, ci)
 	}
+	for n, ci := range loaded {
+		b := builtinCommandInfos[n]
+		ci.Set, ci.Clear = b.Set, b.Clear
+		add(n, ci)
+	}
+	return m
 }
 
 func LookupCommandInfo(commandName string) CommandInfo {
-	if ci, ok := commandInfos[commandName]; ok {
+	m := commandInfos.Load().(map[string]CommandInfo)
+	if ci, ok := m[commandName]; ok {
 		return ci
 	}
-	return commandInfos[strings.ToUpper(commandName)]
+	return m[strings.ToUpper(commandName)]
+}
+
+var errCommandReply = errors.New("RedisGo-Async: unexpected COMMAND reply")
+
+// LoadCommandInfos replaces the command table with the reply to the COMMAND
+// command. Commands missing from the reply keep their built-in description
+// and the connection state bits always come from the built-in table. A nil
+// reply restores the built-in table.
+func LoadCommandInfos(reply []interface{}) error {
+	loaded := make(map[string]CommandInfo, len(reply))
+	for _, v := range reply {
+		name, ci, err := parseCommandInfo(v)
+		if err != nil {
+			return err
+		}
+		loaded[strings.ToUpper(name)] = ci
+	}
+	commandInfos.Store(newCommandInfos(loaded))
+	return nil
+}
+
+var commandFlags = map[string]int{
+	"write":        WriteFlag,
+	"readonly":     ReadOnlyFlag,
+	"blocking":     BlockingFlag,
+	"admin":        AdminFlag,
+	"pubsub":       PubSubFlag,
+	"movablekeys":  MovableKeysFlag,
+	"@write":       WriteFlag,
+	"@read":        ReadOnlyFlag,
+	"@blocking":    BlockingFlag,
+	"@admin":       AdminFlag,
+	"@pubsub":      PubSubFlag,
+	"@transaction": TransactionFlag,
+}
+
+// parseCommandInfo parses one entry of the COMMAND reply: the name, arity,
+// flags, first key, last key, step and, since Redis 6, the ACL categories.
+func parseCommandInfo(v interface{}) (string, CommandInfo, error) {
+	var ci CommandInfo
+	e, ok := v.([]interface{})
+	if !ok || len(e) < 6 {
+		return "", ci, errCommandReply
+	}
+	name, ok := replyString(e[0])
+	if !ok {
+		return "", ci, errCommandReply
+	}
+	ints := []*int{&ci.Arity, nil, &ci.FirstKey, &ci.LastKey, &ci.Step}
+	for i, p := range ints {
+		if p == nil {
+			continue
+		}
+		n, ok := e[i+1].(int64)
+		if !ok {
+			return "", ci, errCommandReply
+		}
+		*p = int(n)
+	}
+	for _, i := range []int{2, 6} {
+		if i >= len(e) {
+			break
+		}
+		flags, ok := e[i].([]interface{})
+		if !ok {
+			return "", ci, errCommandReply
+		}
+		for _, f := range flags {
+			s, _ := replyString(f)
+			ci.Flags |= commandFlags[s]
+		}
+	}
+	return name, ci, nil
+}
+
+func replyString(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case []byte:
+		return string(v), true
+	}
+	return "", false
+}
+
+// ArgString returns a command argument as it is sent to the server.
+func ArgString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case int:
+		return strconv.Itoa(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	case nil:
+		return ""
+	case interface{ RedisArg() interface{} }:
+		return fmt.Sprint(v.RedisArg())
+	}
+	return fmt.Sprint(v)
+}
+
+// KeyIndex returns the index in args of the first key of the command or -1
+// if the command has no key. The args do not include the command name. The
+// first argument is assumed to be the key of commands missing from the table.
+func KeyIndex(commandName string, args []interface{}) int {
+	ci := LookupCommandInfo(commandName)
+	i := 0
+	switch {
+	case ci.Flags&MovableKeysFlag != 0:
+		var ok bool
+		if i, ok = movableKeyIndex(strings.ToUpper(commandName), args); !ok {
+			i = ci.FirstKey - 1
+		}
+	case ci != (CommandInfo{}):
+		i = ci.FirstKey - 1
+	}
+	if i < 0 || i >= len(args) {
+		return -1
+	}
+	return i
+}
+
+// movableKeyIndex finds the first key of commands where the key position
+// depends on the arguments.
+func movableKeyIndex(commandName string, args []interface{}) (int, bool) {
+	numkeys := -1
+	switch commandName {
+	case "EVAL", "EVALSHA", "EVAL_RO", "EVALSHA_RO", "FCALL", "FCALL_RO", "BLMPOP", "BZMPOP":
+		numkeys = 1
+	case "SINTERCARD", "ZDIFF", "ZINTER", "ZINTERCARD", "ZUNION", "LMPOP", "ZMPOP":
+		numkeys = 0
+	case "XREAD", "XREADGROUP":
+		for i, arg := range args {
+			if strings.EqualFold(ArgString(arg), "STREAMS") {
+				return i + 1, true
+			}
+		}
+		return -1, true
+	case "MIGRATE":
+		if len(args) > 2 && ArgString(args[2]) != "" {
+			return 2, true
+		}
+		for i, arg := range args {
+			if strings.EqualFold(ArgString(arg), "KEYS") {
+				return i + 1, true
+			}
+		}
+		return -1, true
+	default:
+		return 0, false
+	}
+	if numkeys >= len(args) {
+		return -1, true
+	}
+	if n, err := strconv.Atoi(ArgString(args[numkeys])); err != nil || n < 1 {
+		return -1, true
+	}
+	return numkeys + 1, true
 }
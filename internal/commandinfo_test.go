@@ -25,3 +25,61 @@ func BenchmarkLookupCommandInfoCorrectCase(b *testing.B) {
 func BenchmarkLookupCommandInfoMixedCase(b *testing.B) {
 	benchmarkLookupCommandInfo(b, "wAtch", "WeTCH", "monItor", "MONiTOR")
 }
+
+var keyIndexTests = []struct {
+	args     []interface{}
+	expected int
+}{
+	{[]interface{}{"GET", "k"}, 0},
+	{[]interface{}{"get", []byte("k")}, 0},
+	{[]interface{}{"PING"}, -1},
+	{[]interface{}{"GET"}, -1},
+	{[]interface{}{"BITOP", "AND", "dest", "k"}, 1},
+	{[]interface{}{"OBJECT", "ENCODING", "k"}, 1},
+	{[]interface{}{"EVAL", "return 1", 0}, -1},
+	{[]interface{}{"EVAL", "return 1", "1", "k"}, 2},
+	{[]interface{}{"FCALL", "f", []byte("2"), "k1", "k2"}, 2},
+	{[]interface{}{"ZUNION", 2, "k1", "k2"}, 1},
+	{[]interface{}{"BLMPOP", 0, 1, "k", "LEFT"}, 2},
+	{[]interface{}{"XREAD", "COUNT", 2, "streams", "k", "0"}, 3},
+	{[]interface{}{"MIGRATE", "host", 6379, "", 0, 5000, "KEYS", "k"}, 6},
+	{[]interface{}{"MIGRATE", "host", 6379, "k", 0, 5000}, 2},
+	{[]interface{}{"SORT", "k", "BY", "w_*"}, 0},
+	{[]interface{}{"MODULE.CMD", "k"}, 0},
+}
+
+func TestKeyIndex(t *testing.T) {
+	for _, tt := range keyIndexTests {
+		cmd, _ := tt.args[0].(string)
+		if i := KeyIndex(cmd, tt.args[1:]); i != tt.expected {
+			t.Errorf("KeyIndex(%v) = %d, want %d", tt.args, i, tt.expected)
+		}
+	}
+}
+
+func TestLoadCommandInfos(t *testing.T) {
+	defer LoadCommandInfos(nil)
+
+	reply := []interface{}{
+		[]interface{}{[]byte("get"), int64(2), []interface{}{"readonly", "fast"}, int64(1), int64(1), int64(1),
+			[]interface{}{"@read", "@string", "@fast"}},
+		[]interface{}{[]byte("watch"), int64(-2), []interface{}{"noscript", "fast"}, int64(1), int64(-1), int64(1)},
+		[]interface{}{[]byte("module.get"), int64(2), []interface{}{"readonly"}, int64(1), int64(1), int64(1)},
+	}
+	if err := LoadCommandInfos(reply); err != nil {
+		t.Fatal(err)
+	}
+	for n, want := range map[string]CommandInfo{
+		"GET":        {Arity: 2, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: 1, Step: 1},
+		"watch":      {Set: WatchState, Arity: -2, FirstKey: 1, LastKey: -1, Step: 1},
+		"MODULE.GET": {Arity: 2, Flags: ReadOnlyFlag, FirstKey: 1, LastKey: 1, Step: 1},
+	} {
+		if ci := LookupCommandInfo(n); ci != want {
+			t.Errorf("LookupCommandInfo(%q) = %+v, want %+v", n, ci, want)
+		}
+	}
+
+	if err := LoadCommandInfos([]interface{}{[]interface{}{"get"}}); err == nil {
+		t.Error("LoadCommandInfos accepted a short entry")
+	}
+}
@@ -15,8 +15,67 @@
 package redis
 
 import (
+	"context"
 	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/gistao/RedisGo-Async/internal"
+)
+
+// ErrConnLost is returned for a request on an async connection when the
+// connection failed before the reply was read. The server may or may not have
+// executed the command. The returned error wraps the cause of the failure, so
+// test for ErrConnLost with errors.Is and get the cause with errors.Unwrap.
+var ErrConnLost = errors.New("RedisGo-Async: connection lost")
+
+// connLostError is ErrConnLost with the cause of the connection failure.
+type connLostError struct {
+	cause error
+}
+
+func (e *connLostError) Error() string {
+	return ErrConnLost.Error() + ": " + e.cause.Error()
+}
+
+func (e *connLostError) Unwrap() error { return e.cause }
+
+func (e *connLostError) Is(target error) bool { return target == ErrConnLost }
+
+// ErrCommandTimeout is returned when the reply to an async command is not
+// received within the command timeout. See DialCommandTimeout.
+var ErrCommandTimeout = errors.New("RedisGo-Async: command timed out")
+
+// ErrQueueFull is returned when a command cannot be queued on an async
+// connection because the queue is full. See DialOverflow.
+var ErrQueueFull = errors.New("RedisGo-Async: request queue full")
+
+// ErrUnsafeCommand is returned for a command that blocks the connection or
+// changes the connection state, such as BLPOP, SUBSCRIBE, MULTI, MONITOR or
+// SELECT. An async connection is shared by all callers, so these commands
+// must be sent on a connection from a Pool instead.
+var ErrUnsafeCommand = errors.New("RedisGo-Async: blocking or stateful command not supported by async connection")
+
+var errTxNotSupported = errors.New("RedisGo-Async: connection does not support AsyncTx")
+
+const defaultQueueSize = 1000
+
+// OverflowPolicy specifies how an async connection handles a command when the
+// request queue is full.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock waits until there is room in the queue.
+	OverflowBlock OverflowPolicy = iota
+
+	// OverflowFail returns ErrQueueFull immediately.
+	OverflowFail
+
+	// OverflowTimeout waits for room in the queue up to the timeout passed
+	// to DialOverflow and then returns ErrQueueFull.
+	OverflowTimeout
 )
 
 type tResult struct {
@@ -29,27 +88,63 @@ type tRequest struct {
 	cmd  string
 	args []interface{}
 	c    chan *tResult
+
+	// tx is set for a transaction. The multi commands are sent between
+	// MULTI and cmd, which is EXEC.
+	tx    bool
+	multi []Command
 }
 
 // send to doreply
 type tReply struct {
 	cmd string
 	c   chan *tResult
+
+	// skip is the number of replies read and discarded before the reply
+	// to cmd.
+	skip int
 }
 
 type asyncRet struct {
-	c chan *tResult
+	c        chan *tResult
+	deadline time.Time
+
+	// sent is set to 1 when the request is written. Accessed with atomic
+	// operations, because callers can wait for the result concurrently.
+	sent int32
 }
 
 // conn is the low-level implementation of Conn
 type asynConn struct {
 	*conn
-	t            time.Time
-	reqChan      chan *tRequest
-	repChan      chan *tReply
-	closeReqChan chan bool
-	closeRepChan chan bool
-	closed       bool
+	reqChan chan *tRequest
+	repChan chan *tReply
+	done    chan struct{}
+
+	// failed is closed on the first fatal error.
+	failed   chan struct{}
+	failOnce sync.Once
+
+	// Callers register in senders with reqMu held for reading and then send
+	// to reqChan without the lock. Stopping the connection closes closing
+	// with reqMu held for writing, which wakes up blocked senders, and closes
+	// reqChan after the senders are done.
+	reqMu   sync.RWMutex
+	closing chan struct{}
+	senders sync.WaitGroup
+
+	overflow        OverflowPolicy
+	overflowTimeout time.Duration
+	commandTimeout  time.Duration
+	livenessTimeout time.Duration
+
+	// inflight is the number of queued requests without a delivered reply.
+	// Accessed with atomic operations.
+	inflight int64
+
+	// active is the time in Unix nanoseconds that the last reply was read.
+	// Accessed with atomic operations.
+	active int64
 }
 
 // AsyncDialTimeout acts like AsyncDial but takes timeouts for establishing the
@@ -66,115 +161,362 @@ func AsyncDialTimeout(network, address string, connectTimeout, readTimeout, writ
 // AsyncDial connects to the Redis server at the given network and
 // address using the specified options.
 func AsyncDial(network, address string, options ...DialOption) (AsynConn, error) {
-	tmp, err := Dial(network, address, options...)
-	if err != nil {
-		return nil, err
-	}
-
-	return getAsynConn(tmp.(*conn))
+	return asyncDial(func() (Conn, error) {
+		return Dial(network, address, options...)
+	}, options)
 }
 
 // AsyncDialURL connects to a Redis server at the given URL using the Redis
 // URI scheme. URLs should follow the draft IANA specification for the
 // scheme (https://www.iana.org/assignments/uri-schemes/prov/redis).
 func AsyncDialURL(rawurl string, options ...DialOption) (AsynConn, error) {
-	tmp, err := DialURL(rawurl, options...)
+	return asyncDial(func() (Conn, error) {
+		return DialURL(rawurl, options...)
+	}, options)
+}
+
+func asyncDial(dial func() (Conn, error), options []DialOption) (AsynConn, error) {
+	var do dialOptions
+	for _, option := range options {
+		option.f(&do)
+	}
+
+	dialAsync := func() (*asynConn, error) {
+		tmp, err := dial()
+		if err != nil {
+			return nil, err
+		}
+		return getAsynConn(tmp.(*conn), &do), nil
+	}
+
+	c, err := dialAsync()
 	if err != nil {
 		return nil, err
 	}
-	return getAsynConn(tmp.(*conn))
+	if !do.reconnect {
+		return c, nil
+	}
+	return newReconnectConn(c, dialAsync, do.minBackoff, do.maxBackoff), nil
 }
 
-func getAsynConn(conn *conn) (AsynConn, error) {
+func getAsynConn(conn *conn, do *dialOptions) *asynConn {
+	queueSize := do.queueSize
+	if queueSize <= 0 {
+		queueSize = defaultQueueSize
+	}
+
 	c := &asynConn{
-		conn:         conn,
-		reqChan:      make(chan *tRequest, 1000),
-		repChan:      make(chan *tReply, 1000),
-		closeReqChan: make(chan bool),
-		closeRepChan: make(chan bool)}
+		conn:            conn,
+		reqChan:         make(chan *tRequest, queueSize),
+		repChan:         make(chan *tReply, queueSize),
+		done:            make(chan struct{}),
+		failed:          make(chan struct{}),
+		closing:         make(chan struct{}),
+		overflow:        do.overflow,
+		overflowTimeout: do.overflowTimeout,
+		commandTimeout:  do.commandTimeout,
+		livenessTimeout: do.livenessTimeout}
 
 	// request routine
 	go c.doRequest()
 	// reply routine
 	go c.doReply()
 
-	return c, nil
+	return c
 }
 
-// Do command to redis server,the goroutine of caller will be suspended.
-func (c *asynConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+// enqueue queues a command for the request routine.
+func (c *asynConn) enqueue(ctx context.Context, cmd string, args []interface{}) (*asyncRet, error) {
 	if cmd == "" {
 		return nil, errors.New("RedisGo-Async: empty command")
 	}
+	if !sharedCommand(cmd, args) {
+		return nil, ErrUnsafeCommand
+	}
+	return c.queue(ctx, &tRequest{cmd: cmd, args: args})
+}
+
+// doSetup acts like Do without rejecting commands that change the connection
+// state. It sets up a connection that is not shared yet, for example with
+// CLIENT TRACKING.
+func (c *asynConn) doSetup(cmd string, args ...interface{}) (interface{}, error) {
+	ret, err := c.queue(context.Background(), &tRequest{cmd: cmd, args: args})
+	if err != nil {
+		return nil, err
+	}
+	return ret.Get()
+}
 
+// queue queues a request for the request routine.
+func (c *asynConn) queue(ctx context.Context, req *tRequest) (*asyncRet, error) {
 	retChan := make(chan *tResult, 2)
+	req.c = retChan
+
+	c.reqMu.RLock()
+	select {
+	case <-c.closing:
+		c.reqMu.RUnlock()
+		return nil, errConnClosed
+	default:
+	}
+	c.senders.Add(1)
+	c.reqMu.RUnlock()
+	defer c.senders.Done()
+
+	atomic.AddInt64(&c.inflight, 1)
+	if err := c.push(ctx, req); err != nil {
+		atomic.AddInt64(&c.inflight, -1)
+		return nil, err
+	}
 
-	c.reqChan <- &tRequest{cmd: cmd, args: args, c: retChan}
+	ret := &asyncRet{c: retChan}
+	if c.commandTimeout != 0 {
+		ret.deadline = time.Now().Add(c.commandTimeout)
+	}
+	return ret, nil
+}
+
+// sharedCommand reports whether a command can be sent on a connection shared
+// by concurrent callers.
+func sharedCommand(cmd string, args []interface{}) bool {
+	ci := internal.LookupCommandInfo(cmd)
+	if ci.Set != 0 || ci.Clear != 0 {
+		return false
+	}
+	switch strings.ToUpper(cmd) {
+	case "PUBLISH", "SPUBLISH", "PUBSUB":
+		return true
+	case "SELECT", "HELLO", "RESET", "QUIT", "AUTH", "READONLY", "READWRITE",
+		"ASKING", "SYNC", "PSYNC", "REPLCONF", "MONITOR":
+		return false
+	case "CLIENT":
+		// These subcommands turn off replies or change the connection state.
+		if len(args) > 0 {
+			switch strings.ToUpper(internal.ArgString(args[0])) {
+			case "REPLY", "TRACKING", "SETNAME", "SETINFO", "CACHING", "NO-EVICT", "NO-TOUCH":
+				return false
+			}
+		}
+	case "SCRIPT":
+		// SCRIPT DEBUG switches the connection to the Lua debugger.
+		if len(args) > 0 && strings.EqualFold(internal.ArgString(args[0]), "DEBUG") {
+			return false
+		}
+	case "XREAD", "XREADGROUP":
+		// Stream reads only block with the BLOCK option.
+		for _, arg := range args {
+			s, _ := arg.(string)
+			if strings.EqualFold(s, "STREAMS") {
+				break
+			}
+			if strings.EqualFold(s, "BLOCK") {
+				return false
+			}
+		}
+		return true
+	}
+	return ci.Flags&(internal.BlockingFlag|internal.PubSubFlag) == 0
+}
 
-	ret := <-retChan
-	if ret.err != nil {
-		return ret.result, ret.err
+// push sends req to the request routine using the overflow policy.
+func (c *asynConn) push(ctx context.Context, req *tRequest) error {
+	select {
+	case c.reqChan <- req:
+		return nil
+	default:
+	}
+
+	var timeout <-chan time.Time
+	switch c.overflow {
+	case OverflowFail:
+		return ErrQueueFull
+	case OverflowTimeout:
+		t := time.NewTimer(c.overflowTimeout)
+		defer t.Stop()
+		timeout = t.C
+	}
+
+	select {
+	case c.reqChan <- req:
+		return nil
+	case <-c.closing:
+		return errConnClosed
+	case <-timeout:
+		return ErrQueueFull
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// QueueLen returns the number of commands waiting to be written.
+func (c *asynConn) QueueLen() int {
+	return len(c.reqChan)
+}
+
+// Do command to redis server,the goroutine of caller will be suspended.
+func (c *asynConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	ret, err := c.enqueue(context.Background(), cmd, args)
+	if err != nil {
+		return nil, err
 	}
-	ret = <-retChan
-	return ret.result, ret.err
+	return ret.Get()
 }
 
 // Do command to redis server,the goroutine of caller is not suspended.
 func (c *asynConn) AsyncDo(cmd string, args ...interface{}) (AsyncRet, error) {
-	if cmd == "" {
-		return nil, errors.New("RedisGo-Async: empty command")
+	ret, err := c.enqueue(context.Background(), cmd, args)
+	if err != nil {
+		return nil, err
 	}
+	return ret, nil
+}
 
-	retChan := make(chan *tResult, 2)
+// DoContext acts like Do but returns ctx.Err() if the context is done before
+// the reply is received. The reply is consumed by the reply routine anyway, so
+// the connection stays in sync.
+func (c *asynConn) DoContext(ctx context.Context, cmd string, args ...interface{}) (interface{}, error) {
+	ret, err := c.enqueue(ctx, cmd, args)
+	if err != nil {
+		return nil, err
+	}
+	return ret.GetContext(ctx)
+}
 
-	c.reqChan <- &tRequest{cmd: cmd, args: args, c: retChan}
+// AsyncDoContext acts like AsyncDo but returns ctx.Err() if the context is
+// done before the command is queued.
+func (c *asynConn) AsyncDoContext(ctx context.Context, cmd string, args ...interface{}) (AsyncRet, error) {
+	ret, err := c.enqueue(ctx, cmd, args)
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
 
-	return &asyncRet{c: retChan}, nil
+// AsyncTx sends the commands in a MULTI/EXEC transaction, the goroutine of
+// caller is not suspended. The result is the reply to EXEC.
+func (c *asynConn) AsyncTx(cmds ...Command) (AsyncRet, error) {
+	return c.AsyncTxContext(context.Background(), cmds...)
 }
 
-func (c *asynConn) Close() error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+// AsyncTxContext acts like AsyncTx but returns ctx.Err() if the context is
+// done before the transaction is queued.
+func (c *asynConn) AsyncTxContext(ctx context.Context, cmds ...Command) (AsyncRet, error) {
+	for _, cmd := range cmds {
+		if cmd.Name == "" {
+			return nil, errors.New("RedisGo-Async: empty command")
+		}
+		if !sharedCommand(cmd.Name, cmd.Args) {
+			return nil, ErrUnsafeCommand
+		}
+	}
+	ret, err := c.queue(ctx, &tRequest{cmd: "EXEC", tx: true, multi: cmds})
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
 
-	if c.closed {
-		return nil
+// stop stops accepting commands. Callers blocked on a full queue fail with
+// errConnClosed. The request and reply routines exit after the queued
+// commands are handled. stop reports whether the call stopped the connection.
+func (c *asynConn) stop() bool {
+	c.reqMu.Lock()
+	select {
+	case <-c.closing:
+		c.reqMu.Unlock()
+		return false
+	default:
 	}
-	c.closed = true
+	close(c.closing)
+	c.reqMu.Unlock()
 
-	go func() {
-		time.Sleep(10 * time.Minute)
-		c.closeReqChan <- true
-	}()
+	c.senders.Wait()
+	close(c.reqChan)
+	return true
+}
+
+// Close closes the connection. Commands waiting for a reply fail with
+// ErrConnLost.
+func (c *asynConn) Close() error {
+	if !c.stop() {
+		return nil
+	}
+	return c.conn.Close()
+}
 
-	c.err = errors.New("RedisGo-Async: closed")
-	return c.conn.conn.Close()
+// CloseGraceful stops accepting commands, waits for the replies to the queued
+// commands and then closes the connection. If ctx is done before all replies
+// are read, then the connection is closed immediately, the remaining commands
+// fail with ErrConnLost and ctx.Err() is returned.
+func (c *asynConn) CloseGraceful(ctx context.Context) error {
+	c.stop()
+
+	select {
+	case <-c.done:
+		return c.conn.Close()
+	case <-ctx.Done():
+		c.conn.Close()
+		<-c.done
+		return ctx.Err()
+	}
 }
 
 func (c *asynConn) doRequest() {
+	defer close(c.repChan)
+
+	var idle <-chan time.Time
+	var timer *time.Timer
+	if c.livenessTimeout != 0 {
+		timer = time.NewTimer(c.livenessTimeout)
+		defer timer.Stop()
+		idle = timer.C
+	}
+
 	for {
-		select {
-		case <-c.closeReqChan:
-			close(c.reqChan)
-			c.closeRepChan <- true
-			return
-
-		case req := <-c.reqChan:
-			for i, length := 0, len(c.reqChan); ; {
-				if c.writeTimeout != 0 {
-					c.conn.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
-				}
-				if err := c.writeCommand(req.cmd, req.args); err != nil {
-					req.c <- &tResult{nil, err}
-					c.fatal(err)
-					break
+		if timer != nil {
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
 				}
-				req.c <- &tResult{nil, nil}
-				c.repChan <- &tReply{cmd: req.cmd, c: req.c}
-				if i++; i > length {
-					break
-				}
-				req = <-c.reqChan
 			}
+			timer.Reset(c.livenessTimeout)
+		}
+
+		var req *tRequest
+		select {
+		case r, ok := <-c.reqChan:
+			if !ok {
+				return
+			}
+			req = r
+		case <-idle:
+			// Check the link with a PING. Nobody waits for the reply.
+			atomic.AddInt64(&c.inflight, 1)
+			req = &tRequest{cmd: "PING", c: make(chan *tResult, 2)}
+		}
+
+		for i, length := 0, len(c.reqChan); ; {
+			if c.writeTimeout != 0 {
+				c.conn.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+			}
+			if err := c.writeRequest(req); err != nil {
+				atomic.AddInt64(&c.inflight, -1)
+				c.fatal(err)
+				req.c <- &tResult{nil, c.lost()}
+				break
+			}
+			req.c <- &tResult{nil, nil}
+			rep := &tReply{cmd: req.cmd, c: req.c}
+			if req.tx {
+				// Discard the replies to MULTI and the queued commands.
+				rep.skip = 1 + len(req.multi)
+			}
+			c.repChan <- rep
+			if i++; i > length {
+				break
+			}
+			req = <-c.reqChan
 		}
 
 		if err := c.bw.Flush(); err != nil {
@@ -184,40 +526,207 @@ func (c *asynConn) doRequest() {
 	}
 }
 
+// writeRequest writes the command of req. A transaction is written as MULTI,
+// the queued commands and EXEC, so that commands from other callers are not
+// queued in the transaction.
+func (c *asynConn) writeRequest(req *tRequest) error {
+	if req.tx {
+		if err := c.writeCommand("MULTI", nil); err != nil {
+			return err
+		}
+		for _, cmd := range req.multi {
+			if err := c.writeCommand(cmd.Name, cmd.Args); err != nil {
+				return err
+			}
+		}
+	}
+	return c.writeCommand(req.cmd, req.args)
+}
+
 func (c *asynConn) doReply() {
-	for {
-		select {
-		case <-c.closeRepChan:
-			close(c.repChan)
-			return
+	defer close(c.done)
+
+	// A slow reply must not fail the connection when the callers already
+	// stop waiting after commandTimeout, so the read timeout is only used
+	// without a command timeout.
+	readTimeout := c.livenessTimeout
+	if readTimeout == 0 && c.commandTimeout == 0 {
+		readTimeout = c.readTimeout
+	}
 
-		case rep := <-c.repChan:
-			if c.readTimeout != 0 {
-				c.conn.conn.SetReadDeadline(time.Now().Add(c.readTimeout))
-			}
-			reply, err := c.readReply()
-			if err != nil {
-				rep.c <- &tResult{nil, err}
-				c.fatal(err)
-				continue
-			} else {
-				c.t = nowFunc()
-			}
-			if e, ok := reply.(Error); ok {
-				err = e
-			}
-			rep.c <- &tResult{reply, err}
+	for rep := range c.repChan {
+		if readTimeout != 0 {
+			c.conn.conn.SetReadDeadline(time.Now().Add(readTimeout))
+		}
+		var reply interface{}
+		var err error
+		for i := 0; i < rep.skip && err == nil; i++ {
+			_, err = c.readResponse()
+		}
+		if err == nil {
+			reply, err = c.readResponse()
 		}
+		atomic.AddInt64(&c.inflight, -1)
+		if err != nil {
+			c.fatal(err)
+			rep.c <- &tResult{nil, c.lost()}
+			continue
+		} else {
+			atomic.StoreInt64(&c.active, nowFunc().UnixNano())
+		}
+		if e, ok := reply.(Error); ok {
+			err = e
+		}
+		rep.c <- &tResult{reply, err}
 	}
 }
 
+// fatal records the first fatal error of the connection and closes failed.
+func (c *asynConn) fatal(err error) error {
+	c.conn.fatal(err)
+	c.failOnce.Do(func() { close(c.failed) })
+	return err
+}
+
+// lost returns ErrConnLost wrapping the error that failed the connection.
+func (c *asynConn) lost() error {
+	return &connLostError{cause: c.Err()}
+}
+
+func (c *asynConn) lastActive() time.Time {
+	if t := atomic.LoadInt64(&c.active); t != 0 {
+		return time.Unix(0, t)
+	}
+	return time.Time{}
+}
+
+func (c *asynConn) inflightCount() int {
+	return int(atomic.LoadInt64(&c.inflight))
+}
+
 // Get get command result asynchronously
 func (a *asyncRet) Get() (interface{}, error) {
-	send := <-a.c
-	if send.err != nil {
-		return send.result, send.err
+	return a.GetContext(context.Background())
+}
+
+// GetContext acts like Get but returns ctx.Err() if the context is done before
+// the result is available. Results are sent on a buffered channel, so an
+// abandoned result never blocks the request and reply routines.
+func (a *asyncRet) GetContext(ctx context.Context) (interface{}, error) {
+	var timeout <-chan time.Time
+	if !a.deadline.IsZero() {
+		t := time.NewTimer(a.deadline.Sub(time.Now()))
+		defer t.Stop()
+		timeout = t.C
+	}
+
+	if atomic.LoadInt32(&a.sent) == 0 {
+		select {
+		case send := <-a.c:
+			if send.err != nil {
+				return send.result, send.err
+			}
+			atomic.StoreInt32(&a.sent, 1)
+		case <-timeout:
+			return nil, ErrCommandTimeout
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	select {
+	case recv := <-a.c:
+		return recv.result, recv.err
+	case <-timeout:
+		return nil, ErrCommandTimeout
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// doContext sends a command on c and waits for the reply until ctx is done.
+// Connections without DoContext are used with asyncDoContext and getContext.
+func doContext(ctx context.Context, c AsynConn, cmd string, args []interface{}) (interface{}, error) {
+	if c, ok := c.(AsynConnWithContext); ok {
+		return c.DoContext(ctx, cmd, args...)
+	}
+	ret, err := asyncDoContext(ctx, c, cmd, args)
+	if err != nil {
+		return nil, err
 	}
+	return getContext(ctx, ret)
+}
 
-	recv := <-a.c
-	return recv.result, recv.err
+// asyncDoContext queues a command on c unless ctx is done.
+func asyncDoContext(ctx context.Context, c AsynConn, cmd string, args []interface{}) (AsyncRet, error) {
+	if c, ok := c.(AsynConnWithContext); ok {
+		return c.AsyncDoContext(ctx, cmd, args...)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.AsyncDo(cmd, args...)
+}
+
+// getContext waits for the result of ret until ctx is done. Results without
+// GetContext are read by a goroutine that ends when the reply arrives.
+func getContext(ctx context.Context, ret AsyncRet) (interface{}, error) {
+	if ret, ok := ret.(AsyncRetWithContext); ok {
+		return ret.GetContext(ctx)
+	}
+	if ctx.Done() == nil {
+		return ret.Get()
+	}
+	c := make(chan *tResult, 1)
+	go func() {
+		reply, err := ret.Get()
+		c <- &tResult{reply, err}
+	}()
+	select {
+	case r := <-c:
+		return r.result, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// closeGraceful closes c after the replies to the commands already sent.
+// Connections without CloseGraceful are closed immediately.
+func closeGraceful(ctx context.Context, c AsynConn) error {
+	if c, ok := c.(GracefulCloser); ok {
+		return c.CloseGraceful(ctx)
+	}
+	return c.Close()
+}
+
+// queueLen returns the number of commands on c waiting to be written, or 0
+// if c does not report it.
+func queueLen(c AsynConn) int {
+	if c, ok := c.(QueueLenReporter); ok {
+		return c.QueueLen()
+	}
+	return 0
+}
+
+// asyncTxContext queues a transaction on c.
+func asyncTxContext(ctx context.Context, c AsynConn, cmds []Command) (AsyncRet, error) {
+	if c, ok := c.(AsyncTxConn); ok {
+		return c.AsyncTxContext(ctx, cmds...)
+	}
+	return nil, errTxNotSupported
+}
+
+// setupConn is implemented by the async connections in this package that
+// accept commands changing the connection state before the connection is
+// shared.
+type setupConn interface {
+	doSetup(cmd string, args ...interface{}) (interface{}, error)
+}
+
+// doSetup sends a command that changes the state of c.
+func doSetup(c AsynConn, cmd string, args ...interface{}) (interface{}, error) {
+	if c, ok := c.(setupConn); ok {
+		return c.doSetup(cmd, args...)
+	}
+	return c.Do(cmd, args...)
 }
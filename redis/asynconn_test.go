@@ -0,0 +1,630 @@
+// Copyright 2017 xiaofei, gistao
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package redis_test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gistao/RedisGo-Async/redis"
+)
+
+// fakeServer answers commands read from one end of a net.Pipe using the
+// handler function. The handler returns the raw reply to write or "" to close
+// the connection.
+type fakeServer struct {
+	handler func(args []string) string
+
+	// If buffered is set, then replies are queued like in the output buffer
+	// of a server and commands are read while the client is not reading
+	// replies.
+	buffered bool
+}
+
+// newEchoServer returns a server that replies to each command with the
+// command name. The reply to SLOW is written after release is closed.
+func newEchoServer(release chan struct{}) *fakeServer {
+	return &fakeServer{handler: func(args []string) string {
+		if args[0] == "SLOW" {
+			<-release
+		}
+		return "+" + args[0] + "\r\n"
+	}}
+}
+
+// newStalledServer returns a server that replies to each command after release
+// is closed.
+func newStalledServer(release chan struct{}) *fakeServer {
+	return &fakeServer{handler: func(args []string) string {
+		<-release
+		return "+OK\r\n"
+	}}
+}
+
+func (s *fakeServer) serve(c net.Conn) {
+	write := func(reply string) error {
+		_, err := c.Write([]byte(reply))
+		return err
+	}
+	if s.buffered {
+		replies := make(chan string, 100)
+		defer close(replies)
+		go func() {
+			defer c.Close()
+			for reply := range replies {
+				c.Write([]byte(reply))
+			}
+		}()
+		write = func(reply string) error {
+			replies <- reply
+			return nil
+		}
+	} else {
+		defer c.Close()
+	}
+
+	br := bufio.NewReader(c)
+	for {
+		args, err := readCommand(br)
+		if err != nil {
+			return
+		}
+		reply := s.handler(args)
+		if reply == "" {
+			return
+		}
+		if err := write(reply); err != nil {
+			return
+		}
+	}
+}
+
+// dial returns a function that connects to the server for use as the Dial
+// field of a pool.
+func (s *fakeServer) dial(options ...redis.DialOption) func() (redis.Conn, error) {
+	return func() (redis.Conn, error) {
+		return redis.Dial("", "", append([]redis.DialOption{dialFakeServer(s)}, options...)...)
+	}
+}
+
+// asyncDial returns a function that connects to the server for use as the
+// Dial field of an async pool.
+func (s *fakeServer) asyncDial(options ...redis.DialOption) func() (redis.AsynConn, error) {
+	return func() (redis.AsynConn, error) {
+		return redis.AsyncDial("", "", append([]redis.DialOption{dialFakeServer(s)}, options...)...)
+	}
+}
+
+func readCommand(br *bufio.Reader) ([]string, error) {
+	line, err := br.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, _ := strconv.Atoi(line[1 : len(line)-2])
+	args := make([]string, n)
+	for i := range args {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		// Read the bulk string by length, arguments can contain "\r\n".
+		n, _ := strconv.Atoi(line[1 : len(line)-2])
+		p := make([]byte, n+2)
+		if _, err := io.ReadFull(br, p); err != nil {
+			return nil, err
+		}
+		args[i] = string(p[:n])
+	}
+	return args, nil
+}
+
+func bulk(s string) string {
+	return "$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n"
+}
+
+// pipeTo returns the client end of a net.Pipe with serve running on the
+// server end.
+func pipeTo(serve func(net.Conn)) net.Conn {
+	client, server := net.Pipe()
+	go serve(server)
+	return client
+}
+
+// dialPipe returns a dial option that connects to serve with a net.Pipe.
+func dialPipe(serve func(net.Conn)) redis.DialOption {
+	return redis.DialNetDial(func(network, addr string) (net.Conn, error) {
+		return pipeTo(serve), nil
+	})
+}
+
+func dialFakeServer(s *fakeServer) redis.DialOption {
+	return dialPipe(s.serve)
+}
+
+// asyncDialFake connects to the server and fails the test on error.
+func asyncDialFake(t *testing.T, s *fakeServer, options ...redis.DialOption) redis.AsynConn {
+	t.Helper()
+	c, err := s.asyncDial(options...)()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func waitFor(t *testing.T, what string, f func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); !f(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestAsyncDoContext(t *testing.T) {
+	release := make(chan struct{})
+	s := newEchoServer(release)
+	c := asyncDialFake(t, s).(redis.AsynConnWithContext)
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := c.DoContext(ctx, "SLOW"); err != context.DeadlineExceeded {
+		t.Fatalf("DoContext returned %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	ret, err := c.AsyncDo("FAST")
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+
+	v, err := redis.String(ret.(redis.AsyncRetWithContext).GetContext(context.Background()))
+	if err != nil || v != "FAST" {
+		t.Fatalf("GetContext returned %q, %v, want %q, nil", v, err, "FAST")
+	}
+}
+
+func TestAsyncReconnect(t *testing.T) {
+	s := &fakeServer{handler: func(args []string) string {
+		if args[0] == "DIE" {
+			return ""
+		}
+		return "+" + args[0] + "\r\n"
+	}}
+	c := asyncDialFake(t, s, redis.DialReconnect(time.Millisecond, 10*time.Millisecond))
+	defer c.Close()
+
+	if _, err := c.Do("DIE"); !errors.Is(err, redis.ErrConnLost) {
+		t.Fatalf("Do(DIE) returned %v, want %v", err, redis.ErrConnLost)
+	}
+	if err := c.Err(); err != nil {
+		t.Fatalf("Err() returned %v, want nil", err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		v, err := redis.String(c.Do("PING"))
+		if err == nil {
+			if v != "PING" {
+				t.Fatalf("Do(PING) returned %q, want %q", v, "PING")
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("connection not restored, last error %v", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestAsyncReconnectBackground(t *testing.T) {
+	var dials int32
+	s := &fakeServer{handler: func(args []string) string {
+		if args[0] == "DIE" {
+			return ""
+		}
+		return "+" + args[0] + "\r\n"
+	}}
+	c, err := redis.AsyncDial("", "",
+		redis.DialNetDial(func(network, addr string) (net.Conn, error) {
+			atomic.AddInt32(&dials, 1)
+			return pipeTo(s.serve), nil
+		}),
+		redis.DialReconnect(time.Millisecond, 10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if _, err := c.Do("DIE"); !errors.Is(err, redis.ErrConnLost) {
+		t.Fatalf("Do(DIE) returned %v, want %v", err, redis.ErrConnLost)
+	}
+	// The connection is redialed without waiting for the next command.
+	waitFor(t, "redial", func() bool { return atomic.LoadInt32(&dials) == 2 })
+}
+
+func TestAsyncReconnectDialError(t *testing.T) {
+	var dials int32
+	errDial := errors.New("dial failed")
+	s := &fakeServer{handler: func(args []string) string {
+		if args[0] == "DIE" {
+			return ""
+		}
+		return "+" + args[0] + "\r\n"
+	}}
+	c, err := redis.AsyncDial("", "",
+		redis.DialNetDial(func(network, addr string) (net.Conn, error) {
+			if atomic.AddInt32(&dials, 1) > 1 {
+				return nil, errDial
+			}
+			return pipeTo(s.serve), nil
+		}),
+		redis.DialReconnect(time.Millisecond, 10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if _, err := c.Do("DIE"); !errors.Is(err, redis.ErrConnLost) {
+		t.Fatalf("Do(DIE) returned %v, want %v", err, redis.ErrConnLost)
+	}
+	waitFor(t, "redial", func() bool { return atomic.LoadInt32(&dials) >= 3 })
+
+	// Commands sent while redialing fail with ErrConnLost caused by the
+	// dial error.
+	_, err = c.Do("PING")
+	if !errors.Is(err, redis.ErrConnLost) || errors.Unwrap(err) != errDial {
+		t.Fatalf("Do(PING) returned %v, want %v caused by %v", err, redis.ErrConnLost, errDial)
+	}
+}
+
+func TestAsyncConnLost(t *testing.T) {
+	s := &fakeServer{handler: func(args []string) string {
+		if args[0] == "DIE" {
+			return ""
+		}
+		return "+" + args[0] + "\r\n"
+	}}
+	c := asyncDialFake(t, s)
+	defer c.Close()
+
+	_, err := c.Do("DIE")
+	if !errors.Is(err, redis.ErrConnLost) || errors.Unwrap(err) != io.EOF {
+		t.Fatalf("Do(DIE) returned %v, want %v caused by EOF", err, redis.ErrConnLost)
+	}
+
+	// The command is not written, because the connection is closed.
+	_, err = c.Do("PING")
+	if !errors.Is(err, redis.ErrConnLost) || errors.Unwrap(err) != io.EOF {
+		t.Fatalf("Do(PING) returned %v, want %v caused by EOF", err, redis.ErrConnLost)
+	}
+}
+
+func TestAsyncCloseGraceful(t *testing.T) {
+	release := make(chan struct{})
+	s := newEchoServer(release)
+	c := asyncDialFake(t, s)
+
+	ret, err := c.AsyncDo("SLOW")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	closed := make(chan error, 1)
+	go func() { closed <- c.(redis.GracefulCloser).CloseGraceful(context.Background()) }()
+
+	time.Sleep(10 * time.Millisecond)
+	if _, err := c.AsyncDo("PING"); err == nil {
+		t.Error("AsyncDo on closing connection returned nil error")
+	}
+
+	close(release)
+	if v, err := redis.String(ret.Get()); err != nil || v != "SLOW" {
+		t.Errorf("Get() returned %q, %v, want %q, nil", v, err, "SLOW")
+	}
+	if err := <-closed; err != nil {
+		t.Errorf("CloseGraceful() returned %v", err)
+	}
+	if c.Err() == nil {
+		t.Error("Err() returned nil after CloseGraceful")
+	}
+}
+
+func TestAsyncCloseGracefulTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	s := newStalledServer(release)
+	c := asyncDialFake(t, s)
+
+	ret, err := c.AsyncDo("BLOCK")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := c.(redis.GracefulCloser).CloseGraceful(ctx); err != context.DeadlineExceeded {
+		t.Errorf("CloseGraceful() returned %v, want %v", err, context.DeadlineExceeded)
+	}
+	if _, err := ret.Get(); !errors.Is(err, redis.ErrConnLost) {
+		t.Errorf("Get() returned %v, want %v", err, redis.ErrConnLost)
+	}
+}
+
+func TestAsyncQueueFull(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	s := newStalledServer(release)
+	c := asyncDialFake(t, s,
+		redis.DialQueueSize(1),
+		redis.DialOverflow(redis.OverflowTimeout, 10*time.Millisecond))
+	defer c.Close()
+
+	for i := 0; ; i++ {
+		if i == 10 {
+			t.Fatal("queue never filled")
+		}
+		_, err := c.AsyncDo("PING")
+		if err == redis.ErrQueueFull {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := c.(redis.QueueLenReporter).QueueLen(); n != 1 {
+		t.Errorf("QueueLen() = %d, want 1", n)
+	}
+}
+
+func TestAsyncCloseBlockedProducers(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	c := asyncDialFake(t, newStalledServer(release), redis.DialQueueSize(1))
+
+	errs := make(chan error, 6)
+	for i := 0; i < 6; i++ {
+		go func() {
+			_, err := c.AsyncDo("PING")
+			errs <- err
+		}()
+	}
+	waitFor(t, "full queue", func() bool { return c.(redis.QueueLenReporter).QueueLen() == 1 })
+	time.Sleep(10 * time.Millisecond)
+
+	closed := make(chan error, 1)
+	go func() { closed <- c.Close() }()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close blocked by callers waiting on a full queue")
+	}
+	for i := 0; i < 6; i++ {
+		select {
+		case <-errs:
+		case <-time.After(time.Second):
+			t.Fatal("AsyncDo blocked after Close")
+		}
+	}
+}
+
+func TestAsyncCommandTimeout(t *testing.T) {
+	release := make(chan struct{})
+	s := newEchoServer(release)
+	c := asyncDialFake(t, s, redis.DialCommandTimeout(10*time.Millisecond))
+	defer c.Close()
+
+	if _, err := c.Do("SLOW"); err != redis.ErrCommandTimeout {
+		t.Fatalf("Do(SLOW) returned %v, want %v", err, redis.ErrCommandTimeout)
+	}
+	close(release)
+	if v, err := redis.String(c.Do("FAST")); err != nil || v != "FAST" {
+		t.Fatalf("Do(FAST) returned %q, %v, want %q, nil", v, err, "FAST")
+	}
+}
+
+func TestAsyncCommandTimeoutSlowReply(t *testing.T) {
+	release := make(chan struct{})
+	s := newEchoServer(release)
+	c := asyncDialFake(t, s,
+		redis.DialReadTimeout(10*time.Millisecond),
+		redis.DialCommandTimeout(10*time.Millisecond))
+	defer c.Close()
+
+	// The reply arrives after the read timeout. The command times out but
+	// the connection is not failed by the read deadline.
+	if _, err := c.Do("SLOW"); err != redis.ErrCommandTimeout {
+		t.Fatalf("Do(SLOW) returned %v, want %v", err, redis.ErrCommandTimeout)
+	}
+	time.Sleep(30 * time.Millisecond)
+	close(release)
+	if v, err := redis.String(c.Do("FAST")); err != nil || v != "FAST" {
+		t.Fatalf("Do(FAST) returned %q, %v, want %q, nil", v, err, "FAST")
+	}
+	if err := c.Err(); err != nil {
+		t.Fatalf("Err() returned %v", err)
+	}
+}
+
+func TestAsyncLivenessPing(t *testing.T) {
+	pings := make(chan struct{}, 10)
+	s := &fakeServer{handler: func(args []string) string {
+		if args[0] == "PING" {
+			pings <- struct{}{}
+		}
+		return "+PONG\r\n"
+	}}
+	c := asyncDialFake(t, s, redis.DialLivenessTimeout(10*time.Millisecond))
+	defer c.Close()
+
+	// The connection keeps sending a PING each time it has been idle for the
+	// liveness timeout.
+	for i := 0; i < 3; i++ {
+		select {
+		case <-pings:
+		case <-time.After(time.Second):
+			t.Fatal("idle connection did not send PING")
+		}
+	}
+	if err := c.Err(); err != nil {
+		t.Fatalf("Err() returned %v", err)
+	}
+}
+
+func TestAsyncLastActive(t *testing.T) {
+	c := asyncDialFake(t, newEchoServer(nil))
+	defer c.Close()
+
+	// The time of the last reply is read while the reply routine updates it.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			if _, err := c.Do("PING"); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			if redis.LastActive(c).IsZero() {
+				t.Error("LastActive() returned the zero time after reading replies")
+			}
+			return
+		default:
+			redis.LastActive(c)
+		}
+	}
+}
+
+var unsafeCommandTests = []struct {
+	args []interface{}
+	safe bool
+}{
+	{[]interface{}{"GET", "k"}, true},
+	{[]interface{}{"PUBLISH", "c", "m"}, true},
+	{[]interface{}{"XREAD", "STREAMS", "k", "0"}, true},
+	{[]interface{}{"BLPOP", "k", 0}, false},
+	{[]interface{}{"subscribe", "c"}, false},
+	{[]interface{}{"UNSUBSCRIBE"}, false},
+	{[]interface{}{"MULTI"}, false},
+	{[]interface{}{"EXEC"}, false},
+	{[]interface{}{"MONITOR"}, false},
+	{[]interface{}{"SELECT", 1}, false},
+	{[]interface{}{"XREAD", "BLOCK", 0, "STREAMS", "k", "$"}, false},
+	{[]interface{}{"AUTH", "user", "password"}, false},
+	{[]interface{}{"READONLY"}, false},
+	{[]interface{}{"READWRITE"}, false},
+	{[]interface{}{"ASKING"}, false},
+	{[]interface{}{"SYNC"}, false},
+	{[]interface{}{"PSYNC", "?", -1}, false},
+	{[]interface{}{"CLIENT", "REPLY", "OFF"}, false},
+	{[]interface{}{"CLIENT", "reply", "SKIP"}, false},
+	{[]interface{}{"CLIENT", "TRACKING", "ON"}, false},
+	{[]interface{}{"CLIENT", []byte("SETNAME"), "name"}, false},
+	{[]interface{}{"SCRIPT", "DEBUG", "YES"}, false},
+	{[]interface{}{"CLIENT", "ID"}, true},
+	{[]interface{}{"SCRIPT", "EXISTS", "sha"}, true},
+}
+
+func TestAsyncUnsafeCommand(t *testing.T) {
+	s := &fakeServer{handler: func(args []string) string { return "+OK\r\n" }}
+	c := asyncDialFake(t, s)
+	defer c.Close()
+
+	for _, tt := range unsafeCommandTests {
+		_, err := c.Do(tt.args[0].(string), tt.args[1:]...)
+		if tt.safe && err != nil {
+			t.Errorf("Do(%v) returned %v", tt.args, err)
+		}
+		if !tt.safe && err != redis.ErrUnsafeCommand {
+			t.Errorf("Do(%v) returned %v, want %v", tt.args, err, redis.ErrUnsafeCommand)
+		}
+	}
+}
+
+func TestAsyncTx(t *testing.T) {
+	var commands []string
+	multi := false
+	s := &fakeServer{handler: func(args []string) string {
+		commands = append(commands, args[0])
+		switch {
+		case args[0] == "MULTI":
+			multi = true
+			return "+OK\r\n"
+		case args[0] == "EXEC":
+			multi = false
+			return "*2\r\n+OK\r\n:2\r\n"
+		case multi:
+			return "+QUEUED\r\n"
+		}
+		return "+PONG\r\n"
+	}}
+	c := asyncDialFake(t, s).(redis.AsyncTxConn)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			ret, err := c.AsyncTx(
+				redis.Command{Name: "SET", Args: []interface{}{"a", 1}},
+				redis.Command{Name: "INCR", Args: []interface{}{"a"}},
+			)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			v, err := redis.Values(ret.Get())
+			if want := []interface{}{"OK", int64(2)}; err != nil || !reflect.DeepEqual(v, want) {
+				t.Errorf("AsyncTx() = %#v, %v, want %#v", v, err, want)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if v, err := redis.String(c.Do("PING")); err != nil || v != "PONG" {
+				t.Errorf("Do(PING) = %q, %v, want PONG", v, err)
+			}
+		}()
+	}
+	wg.Wait()
+	c.Close()
+
+	for i := 0; i < len(commands); i++ {
+		if commands[i] != "MULTI" {
+			continue
+		}
+		if got := strings.Join(commands[i:i+4], " "); got != "MULTI SET INCR EXEC" {
+			t.Fatalf("transaction written as %s", got)
+		}
+	}
+
+	if _, err := c.AsyncTx(redis.Command{Name: "BLPOP", Args: []interface{}{"list", 0}}); err != redis.ErrUnsafeCommand {
+		t.Errorf("AsyncTx(BLPOP) returned %v, want %v", err, redis.ErrUnsafeCommand)
+	}
+}
@@ -15,6 +15,7 @@
 package redis
 
 import (
+	"context"
 	"errors"
 	"sync"
 	"sync/atomic"
@@ -23,7 +24,20 @@ import (
 
 var errorCompatibility = errors.New("RedisGo-Async: should use AsyncDo func")
 
-// AsyncPool maintains one connection.
+// Balance is the policy used by AsyncPool to choose a connection for a
+// command.
+type Balance int
+
+const (
+	// RoundRobin uses the pool connections in turn.
+	RoundRobin Balance = iota
+
+	// LeastPending uses the connection with the fewest requests waiting for
+	// a reply.
+	LeastPending
+)
+
+// AsyncPool maintains one or more connections.
 type AsyncPool struct {
 	// Dial is an application supplied function for creating and configuring a
 	// connection.
@@ -43,23 +57,145 @@ type AsyncPool struct {
 	// MaxGetCount is the maximum value that limits the hang up 'Do()' goroutine.
 	// When zero, there is no limit.
 	MaxDoCount int
-
-	c        *asyncPoolConnection
+	// MaxActive is the number of connections maintained by the pool. When
+	// zero, the pool maintains one connection.
+	MaxActive int
+	// Balance chooses the pool connection for each command when MaxActive
+	// is greater than one.
+	Balance Balance
+
+	slots    []*asyncSlot
 	mu       sync.Mutex
 	cond     *sync.Cond
 	getCount int
 	doCount  int32
 	closed   bool
+	stats    PoolStats
+
+	// conns is a snapshot of the slot connections read by asyncPoolBalancer
+	// without locking mu. It is stored with mu held.
+	conns atomic.Value
+
+	// next is the round robin counter. Accessed with atomic operations.
+	next uint32
+}
+
+// AsyncPoolStats contains async pool statistics. The IdleClosed and
+// LifetimeClosed counters of PoolStats are not used by AsyncPool.
+type AsyncPoolStats struct {
+	PoolStats
+
+	// InflightCount is the number of requests on the pool connections
+	// waiting for a reply.
+	InflightCount int
+
+	// QueueLen is the number of commands on the pool connections waiting to
+	// be written to the server.
+	QueueLen int
+}
+
+type asyncSlot struct {
+	c        *asyncPoolConnection
 	blocking bool
 }
 
+// asyncConnInfo is implemented by the async connections in this package.
+type asyncConnInfo interface {
+	// lastActive returns the time that the connection last read a reply.
+	lastActive() time.Time
+	// inflightCount returns the number of requests waiting for a reply.
+	inflightCount() int
+}
+
 // NewAsyncPool creates a new async pool.
 func NewAsyncPool(newFn func() (AsynConn, error), testFn func(AsynConn, time.Time) error) *AsyncPool {
 	return &AsyncPool{Dial: newFn, TestOnBorrow: testFn}
 }
 
+// slot returns slot i, or the slot chosen by Balance if i is negative. The
+// caller must hold p.mu during the call.
+func (p *AsyncPool) slot(i int) *asyncSlot {
+	if p.slots == nil {
+		n := p.MaxActive
+		if n < 1 {
+			n = 1
+		}
+		p.slots = make([]*asyncSlot, n)
+		for i := range p.slots {
+			p.slots[i] = &asyncSlot{}
+		}
+	}
+	if i >= 0 {
+		return p.slots[i]
+	}
+	if len(p.slots) == 1 {
+		return p.slots[0]
+	}
+
+	if p.Balance == LeastPending {
+		var best *asyncSlot
+		min := -1
+		for _, s := range p.slots {
+			n := 0
+			if s.c != nil && s.c.Err() == nil {
+				n = inflightCount(s.c.c)
+			}
+			if min < 0 || n < min {
+				best, min = s, n
+			}
+		}
+		return best
+	}
+
+	return p.slots[atomic.AddUint32(&p.next, 1)%uint32(len(p.slots))]
+}
+
+// storeConns stores the snapshot of the slot connections. The caller must
+// hold p.mu during the call.
+func (p *AsyncPool) storeConns() {
+	conns := make([]*asyncPoolConnection, len(p.slots))
+	for i, s := range p.slots {
+		conns[i] = s.c
+	}
+	p.conns.Store(conns)
+}
+
 // Get gets a connection.
 func (p *AsyncPool) Get() AsynConn {
+	c, err := p.GetContext(context.Background())
+	if err != nil {
+		return errorConnection{err}
+	}
+	return c
+}
+
+// GetContext acts like Get but returns ctx.Err() if the context is done
+// while waiting for another Get to dial or test the connection. Waiting
+// calls count against MaxGetCount.
+//
+// When MaxActive is greater than one, GetContext dials or tests every pool
+// connection and the returned connection sends each command on the pool
+// connection chosen by Balance.
+func (p *AsyncPool) GetContext(ctx context.Context) (AsynConn, error) {
+	if p.MaxActive <= 1 {
+		return p.get(ctx, 0, true)
+	}
+	for i := 0; i < p.MaxActive; i++ {
+		if _, err := p.get(ctx, i, true); err != nil {
+			return nil, err
+		}
+	}
+	return &asyncPoolBalancer{p: p}, nil
+}
+
+// get returns the connection of slot i, or of the slot chosen by Balance if i
+// is negative, dialing the connection if needed. If borrow is set, then
+// TestOnBorrow checks an existing connection.
+func (p *AsyncPool) get(ctx context.Context, i int, borrow bool) (*asyncPoolConnection, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	p.mu.Lock()
 	if p.cond == nil {
 		p.cond = sync.NewCond(&p.mu)
@@ -68,94 +204,191 @@ func (p *AsyncPool) Get() AsynConn {
 	p.getCount++
 	if p.MaxGetCount != 0 && p.getCount > p.MaxGetCount {
 		p.getCount--
+		p.stats.Exhausted++
 		p.mu.Unlock()
-		return errorConnection{ErrPoolExhausted}
+		return nil, ErrPoolExhausted
 	}
 
-	var pc AsynConn
+	var pc *asyncPoolConnection
+	s := p.slot(i)
+	waited := false
 	for {
 		if p.closed {
 			p.getCount--
 			p.mu.Unlock()
-			return errorConnection{errPoolClosed}
+			return nil, errPoolClosed
 		}
 
-		if p.blocking {
-			p.cond.Wait()
+		if s.blocking {
+			if !waited {
+				waited = true
+				p.stats.WaitCount++
+			}
+			start := time.Now()
+			waitContext(ctx, p.cond)
+			p.stats.WaitDuration += time.Since(start)
+			if err := ctx.Err(); err != nil {
+				p.stats.Timeouts++
+				p.getCount--
+				p.mu.Unlock()
+				return nil, err
+			}
 			continue
 		}
 
-		if p.c != nil && p.c.Err() == nil {
-			if test := p.TestOnBorrow; test != nil {
-				p.blocking = true
-				ic := p.c.c.(*asynConn)
+		if s.c != nil && s.c.Err() == nil {
+			if test := p.TestOnBorrow; test != nil && borrow {
+				s.blocking = true
+				t := lastActive(s.c.c)
 				p.mu.Unlock()
 
-				err := test(p.c, ic.t)
+				err := test(s.c, t)
 
 				p.mu.Lock()
-				p.blocking = false
+				s.blocking = false
 				if err == nil {
-					pc = p.c
+					pc = s.c
 					p.getCount--
-					p.cond.Signal()
+					p.cond.Broadcast()
 					p.mu.Unlock()
-					return pc
+					return pc, nil
 				}
+				p.stats.TestFailures++
 			} else {
-				pc = p.c
+				pc = s.c
 				p.getCount--
-				p.cond.Signal()
+				p.cond.Broadcast()
 				p.mu.Unlock()
-				return pc
+				return pc, nil
 			}
 		}
 
-		if p.c != nil {
-			p.c.c.Close()
+		var old AsynConn
+		if s.c != nil {
+			p.stats.ErrorClosed++
+			old = s.c.c
+			s.c = nil
+			p.storeConns()
 		}
-		p.blocking = true
+		s.blocking = true
 		p.mu.Unlock()
 
+		if old != nil {
+			old.Close()
+		}
 		c, err := p.Dial()
 
 		p.mu.Lock()
-		p.blocking = false
+		s.blocking = false
+		p.stats.Dials++
 		if err != nil {
+			p.stats.DialErrors++
 			p.getCount--
-			p.cond.Signal()
+			p.cond.Broadcast()
 			p.mu.Unlock()
-			return errorConnection{err}
+			return nil, err
+		}
+		if p.closed {
+			p.getCount--
+			p.cond.Broadcast()
+			p.mu.Unlock()
+			c.Close()
+			return nil, errPoolClosed
 		}
 
-		p.c = &asyncPoolConnection{p: p, c: c}
-		pc := p.c
+		s.c = &asyncPoolConnection{p: p, c: c}
+		p.storeConns()
+		pc = s.c
 		p.getCount--
-		p.cond.Signal()
+		p.cond.Broadcast()
 		p.mu.Unlock()
 
-		return pc
+		return pc, nil
 	}
 }
 
-// ActiveCount returns the number of client of this pool.
+// ActiveCount returns the number of usable connections in the pool.
 func (p *AsyncPool) ActiveCount() int {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	if p.c != nil && p.c.Err() == nil {
-		return 1
+	active := 0
+	for _, s := range p.slots {
+		if s.c != nil && s.c.Err() == nil {
+			active++
+		}
 	}
-	return 0
+	return active
 }
 
-// IdleCount returns the number of idle connections in the pool.
+// IdleCount returns the number of usable connections in the pool that have no
+// requests waiting for a reply.
 func (p *AsyncPool) IdleCount() int {
-	return 0
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	idle := 0
+	for _, s := range p.slots {
+		if s.c != nil && s.c.Err() == nil && inflightCount(s.c.c) == 0 {
+			idle++
+		}
+	}
+	return idle
+}
+
+// Stats returns a snapshot of the pool statistics.
+func (p *AsyncPool) Stats() AsyncPoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stats := AsyncPoolStats{PoolStats: p.stats}
+	for _, s := range p.slots {
+		if s.c == nil || s.c.Err() != nil {
+			continue
+		}
+		n := inflightCount(s.c.c)
+		stats.ActiveCount++
+		if n == 0 {
+			stats.IdleCount++
+		}
+		stats.InflightCount += n
+		stats.QueueLen += queueLen(s.c.c)
+	}
+	return stats
 }
 
 // Close releases the resources used by the pool.
 func (p *AsyncPool) Close() error {
+	conns := p.release()
+
+	var err error
+	for _, c := range conns {
+		if e := c.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// CloseGraceful acts like Close but waits for the replies to the commands
+// already sent on the pool connections. If ctx is done first, then the
+// remaining connections are closed immediately and ctx.Err() is returned.
+func (p *AsyncPool) CloseGraceful(ctx context.Context) error {
+	conns := p.release()
+
+	var err error
+	for _, c := range conns {
+		if e := closeGraceful(ctx, c); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// release marks the pool closed and returns the pool connections. The
+// connections are closed by the caller without holding p.mu, because closing
+// a connection waits for callers blocked on a full queue.
+func (p *AsyncPool) release() []AsynConn {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
@@ -166,10 +399,31 @@ func (p *AsyncPool) Close() error {
 	if p.cond != nil {
 		p.cond.Broadcast()
 	}
-	err := p.c.c.Close()
-	p.c = nil
+	var conns []AsynConn
+	for _, s := range p.slots {
+		if s.c != nil {
+			conns = append(conns, s.c.c)
+			s.c = nil
+		}
+	}
+	p.storeConns()
+	return conns
+}
 
-	return err
+// lastActive returns the time that c last read a reply from the server.
+func lastActive(c AsynConn) time.Time {
+	if c, ok := c.(asyncConnInfo); ok {
+		return c.lastActive()
+	}
+	return time.Time{}
+}
+
+// inflightCount returns the number of requests on c waiting for a reply.
+func inflightCount(c AsynConn) int {
+	if c, ok := c.(asyncConnInfo); ok {
+		return c.inflightCount()
+	}
+	return 0
 }
 
 type asyncPoolConnection struct {
@@ -181,6 +435,14 @@ func (pc *asyncPoolConnection) Close() error {
 	return nil
 }
 
+func (pc *asyncPoolConnection) CloseGraceful(ctx context.Context) error {
+	return nil
+}
+
+func (pc *asyncPoolConnection) QueueLen() int {
+	return queueLen(pc.c)
+}
+
 func (pc *asyncPoolConnection) Err() error {
 	return pc.c.Err()
 }
@@ -204,6 +466,33 @@ func (pc *asyncPoolConnection) AsyncDo(commandName string, args ...interface{})
 	return pc.c.AsyncDo(commandName, args...)
 }
 
+func (pc *asyncPoolConnection) DoContext(ctx context.Context, commandName string, args ...interface{}) (reply interface{}, err error) {
+	if pc.p.MaxDoCount != 0 {
+		if atomic.AddInt32(&pc.p.doCount, 1) > int32(pc.p.MaxDoCount) {
+			atomic.AddInt32(&pc.p.doCount, -1)
+			return nil, ErrPoolExhausted
+		}
+
+		defer func() {
+			atomic.AddInt32(&pc.p.doCount, -1)
+		}()
+	}
+
+	return doContext(ctx, pc.c, commandName, args)
+}
+
+func (pc *asyncPoolConnection) AsyncDoContext(ctx context.Context, commandName string, args ...interface{}) (ret AsyncRet, err error) {
+	return asyncDoContext(ctx, pc.c, commandName, args)
+}
+
+func (pc *asyncPoolConnection) AsyncTx(cmds ...Command) (ret AsyncRet, err error) {
+	return asyncTxContext(context.Background(), pc.c, cmds)
+}
+
+func (pc *asyncPoolConnection) AsyncTxContext(ctx context.Context, cmds ...Command) (ret AsyncRet, err error) {
+	return asyncTxContext(ctx, pc.c, cmds)
+}
+
 func (pc *asyncPoolConnection) Send(commandName string, args ...interface{}) error {
 	return errorCompatibility
 }
@@ -216,4 +505,149 @@ func (pc *asyncPoolConnection) Receive() (reply interface{}, err error) {
 	return nil, errorCompatibility
 }
 
+// asyncPoolBalancer is the connection returned by Get when the pool has more
+// than one connection. It sends each command on the pool connection chosen
+// by Balance.
+type asyncPoolBalancer struct {
+	p *AsyncPool
+}
+
+// connPicker is implemented by connections that send each command on one of
+// several connections.
+type connPicker interface {
+	// pickConn returns the connection for the next command.
+	pickConn(ctx context.Context) (AsynConn, error)
+}
+
+func (b *asyncPoolBalancer) pickConn(ctx context.Context) (AsynConn, error) {
+	pc, err := b.pick(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return pc, nil
+}
+
+// pick returns the pool connection chosen by Balance. The connection is
+// picked from the snapshot of the slot connections without locking the pool.
+func (b *asyncPoolBalancer) pick(ctx context.Context) (*asyncPoolConnection, error) {
+	conns, _ := b.p.conns.Load().([]*asyncPoolConnection)
+	var best *asyncPoolConnection
+	if n := uint32(len(conns)); n > 0 {
+		if b.p.Balance == LeastPending {
+			min := -1
+			for _, pc := range conns {
+				if pc == nil || pc.Err() != nil {
+					continue
+				}
+				if k := inflightCount(pc.c); min < 0 || k < min {
+					best, min = pc, k
+				}
+			}
+		} else {
+			start := atomic.AddUint32(&b.p.next, 1)
+			for i := uint32(0); i < n; i++ {
+				if pc := conns[(start+i)%n]; pc != nil && pc.Err() == nil {
+					best = pc
+					break
+				}
+			}
+		}
+	}
+	if best != nil {
+		return best, nil
+	}
+
+	// No usable connection in the snapshot. Redial through the pool.
+	return b.p.get(ctx, -1, false)
+}
+
+func (b *asyncPoolBalancer) Close() error {
+	return nil
+}
+
+func (b *asyncPoolBalancer) CloseGraceful(ctx context.Context) error {
+	return nil
+}
+
+func (b *asyncPoolBalancer) QueueLen() int {
+	return b.p.Stats().QueueLen
+}
+
+func (b *asyncPoolBalancer) Err() error {
+	b.p.mu.Lock()
+	defer b.p.mu.Unlock()
+	if b.p.closed {
+		return errPoolClosed
+	}
+	return nil
+}
+
+func (b *asyncPoolBalancer) Do(commandName string, args ...interface{}) (reply interface{}, err error) {
+	pc, err := b.pick(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return pc.Do(commandName, args...)
+}
+
+func (b *asyncPoolBalancer) AsyncDo(commandName string, args ...interface{}) (ret AsyncRet, err error) {
+	pc, err := b.pick(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return pc.AsyncDo(commandName, args...)
+}
+
+func (b *asyncPoolBalancer) DoContext(ctx context.Context, commandName string, args ...interface{}) (reply interface{}, err error) {
+	pc, err := b.pick(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return pc.DoContext(ctx, commandName, args...)
+}
+
+func (b *asyncPoolBalancer) AsyncDoContext(ctx context.Context, commandName string, args ...interface{}) (ret AsyncRet, err error) {
+	pc, err := b.pick(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return pc.AsyncDoContext(ctx, commandName, args...)
+}
+
+func (b *asyncPoolBalancer) AsyncTx(cmds ...Command) (ret AsyncRet, err error) {
+	return b.AsyncTxContext(context.Background(), cmds...)
+}
+
+func (b *asyncPoolBalancer) AsyncTxContext(ctx context.Context, cmds ...Command) (ret AsyncRet, err error) {
+	pc, err := b.pick(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return pc.AsyncTxContext(ctx, cmds...)
+}
+
+func (b *asyncPoolBalancer) Send(commandName string, args ...interface{}) error {
+	return errorCompatibility
+}
+
+func (b *asyncPoolBalancer) Flush() error {
+	return errorCompatibility
+}
+
+func (b *asyncPoolBalancer) Receive() (reply interface{}, err error) {
+	return nil, errorCompatibility
+}
+
 func (ec errorConnection) AsyncDo(string, ...interface{}) (AsyncRet, error) { return nil, ec.err }
+func (ec errorConnection) DoContext(context.Context, string, ...interface{}) (interface{}, error) {
+	return nil, ec.err
+}
+func (ec errorConnection) AsyncDoContext(context.Context, string, ...interface{}) (AsyncRet, error) {
+	return nil, ec.err
+}
+func (ec errorConnection) AsyncTx(...Command) (AsyncRet, error) { return nil, ec.err }
+func (ec errorConnection) AsyncTxContext(context.Context, ...Command) (AsyncRet, error) {
+	return nil, ec.err
+}
+func (ec errorConnection) CloseGraceful(context.Context) error { return ec.err }
+func (ec errorConnection) QueueLen() int                       { return 0 }
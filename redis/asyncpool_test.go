@@ -0,0 +1,236 @@
+// Copyright 2017 xiaofei, gistao
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package redis_test
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gistao/RedisGo-Async/redis"
+)
+
+// numberedDial returns a dial function for connections that reply to each
+// command with the number of the connection. The reply to SLOW is written
+// after release is closed.
+func numberedDial(release chan struct{}) func() (redis.AsynConn, error) {
+	n := 0
+	return func() (redis.AsynConn, error) {
+		n++
+		id := strconv.Itoa(n)
+		s := &fakeServer{handler: func(args []string) string {
+			if args[0] == "SLOW" {
+				<-release
+			}
+			return "+" + id + "\r\n"
+		}}
+		return s.asyncDial()()
+	}
+}
+
+func TestAsyncPoolRoundRobin(t *testing.T) {
+	p := &redis.AsyncPool{MaxActive: 3, Balance: redis.RoundRobin, Dial: numberedDial(nil)}
+	defer p.Close()
+
+	// Get uses the first connection, the commands of one caller are sent
+	// on all connections in turn.
+	c := p.Get()
+	var got []string
+	for i := 0; i < 6; i++ {
+		v, err := redis.String(c.Do("PING"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, v)
+	}
+	if want := []string{"2", "3", "1", "2", "3", "1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("commands sent on connections %v, want %v", got, want)
+	}
+	if dials := p.Stats().Dials; dials != 3 {
+		t.Errorf("Stats().Dials = %d, want 3", dials)
+	}
+	if n := p.ActiveCount(); n != 3 {
+		t.Errorf("ActiveCount() = %d, want 3", n)
+	}
+	if n := p.IdleCount(); n != 3 {
+		t.Errorf("IdleCount() = %d, want 3", n)
+	}
+}
+
+func TestAsyncPoolBalancerBorrow(t *testing.T) {
+	tested := map[string]int{}
+	p := &redis.AsyncPool{
+		MaxActive:   2,
+		MaxGetCount: 1,
+		Dial:        numberedDial(nil),
+		TestOnBorrow: func(c redis.AsynConn, _ time.Time) error {
+			id, err := redis.String(c.Do("PING"))
+			tested[id]++
+			return err
+		},
+	}
+	defer p.Close()
+
+	// The first Get dials the connections, the second Get tests them.
+	p.Get()
+	c := p.Get()
+	if want := map[string]int{"1": 1, "2": 1}; !reflect.DeepEqual(tested, want) {
+		t.Errorf("TestOnBorrow called for connections %v, want %v", tested, want)
+	}
+
+	// Commands on the returned connection are not counted as borrows.
+	before := p.Stats()
+	for i := 0; i < 4; i++ {
+		if _, err := c.Do("PING"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if after := p.Stats(); after.PoolStats != before.PoolStats {
+		t.Errorf("Stats() after commands = %+v, want %+v", after.PoolStats, before.PoolStats)
+	}
+}
+
+func TestAsyncPoolLeastPending(t *testing.T) {
+	release := make(chan struct{})
+	p := &redis.AsyncPool{MaxActive: 2, Balance: redis.LeastPending, Dial: numberedDial(release)}
+	defer p.Close()
+
+	c := p.Get()
+	ret, err := c.AsyncDo("SLOW")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if v, err := redis.String(c.Do("PING")); err != nil || v != "2" {
+			t.Fatalf("Do(PING) = %q, %v, want the idle connection 2", v, err)
+		}
+	}
+	if n := p.IdleCount(); n != 1 {
+		t.Errorf("IdleCount() = %d, want 1", n)
+	}
+	close(release)
+	if v, err := redis.String(ret.Get()); err != nil || v != "1" {
+		t.Fatalf("AsyncDo(SLOW) = %q, %v, want connection 1", v, err)
+	}
+}
+
+func TestAsyncPoolGetContext(t *testing.T) {
+	s := newEchoServer(nil)
+	dialing := make(chan struct{})
+	release := make(chan struct{})
+	p := &redis.AsyncPool{
+		Dial: func() (redis.AsynConn, error) {
+			close(dialing)
+			<-release
+			return s.asyncDial()()
+		},
+	}
+	defer p.Close()
+
+	got := make(chan redis.AsynConn, 1)
+	go func() { got <- p.Get() }()
+	<-dialing
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := p.GetContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("GetContext() returned %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	close(release)
+	c := <-got
+	if _, err := c.Do("PING"); err != nil {
+		t.Fatal(err)
+	}
+	c2, err := p.GetContext(context.Background())
+	if err != nil || c2 != c {
+		t.Errorf("GetContext() = %v, %v, want the dialed connection", c2, err)
+	}
+}
+
+func TestAsyncPoolStats(t *testing.T) {
+	release := make(chan struct{})
+	s := newEchoServer(release)
+	p := &redis.AsyncPool{MaxActive: 2, Balance: redis.LeastPending, Dial: s.asyncDial()}
+	defer p.Close()
+
+	ret, err := p.Get().AsyncDo("SLOW")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Get().Do("PING"); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := p.Stats()
+	want := redis.AsyncPoolStats{
+		PoolStats:     redis.PoolStats{ActiveCount: 2, IdleCount: 1, Dials: 2},
+		InflightCount: 1,
+	}
+	if stats != want {
+		t.Errorf("Stats() = %+v, want %+v", stats, want)
+	}
+
+	close(release)
+	if _, err := ret.Get(); err != nil {
+		t.Fatal(err)
+	}
+	if stats := p.Stats(); stats.IdleCount != 2 || stats.InflightCount != 0 {
+		t.Errorf("Stats() = %+v, want 2 idle connections and no requests in flight", stats)
+	}
+}
+
+// minimalAsynConn hides the optional methods of the wrapped connection, like
+// an application supplied AsynConn that implements only the interface.
+type minimalAsynConn struct {
+	redis.AsynConn
+}
+
+func TestAsyncPoolMinimalConn(t *testing.T) {
+	release := make(chan struct{})
+	s := newEchoServer(release)
+	p := &redis.AsyncPool{
+		Dial: func() (redis.AsynConn, error) {
+			c, err := s.asyncDial()()
+			if err != nil {
+				return nil, err
+			}
+			return minimalAsynConn{c}, nil
+		},
+	}
+	defer p.Close()
+
+	c, ok := p.Get().(redis.AsynConnWithContext)
+	if !ok {
+		t.Fatal("pool connection does not implement AsynConnWithContext")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := c.DoContext(ctx, "SLOW"); err != context.DeadlineExceeded {
+		t.Fatalf("DoContext returned %v, want %v", err, context.DeadlineExceeded)
+	}
+	close(release)
+	if v, err := redis.String(c.DoContext(context.Background(), "FAST")); err != nil || v != "FAST" {
+		t.Fatalf("DoContext returned %q, %v, want %q, nil", v, err, "FAST")
+	}
+	if _, err := c.(redis.AsyncTxConn).AsyncTx(redis.Command{Name: "INCR", Args: []interface{}{"k"}}); err == nil {
+		t.Error("AsyncTx returned nil error for a connection without transactions")
+	}
+	if err := p.CloseGraceful(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
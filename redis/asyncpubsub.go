@@ -0,0 +1,458 @@
+// Copyright 2017 xiaofei, gistao
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package redis
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+const defaultPubSubBufferSize = 100
+
+var errPubSubClosed = errors.New("RedisGo-Async: pubsub closed")
+
+// SlowConsumerPolicy specifies how AsyncPubSub handles a notification when
+// the channel of a subscription is full.
+type SlowConsumerPolicy int
+
+const (
+	// SlowConsumerDropNewest discards the notification.
+	SlowConsumerDropNewest SlowConsumerPolicy = iota
+
+	// SlowConsumerDropOldest discards the oldest buffered notification to
+	// make room for the notification.
+	SlowConsumerDropOldest
+
+	// SlowConsumerBlock waits until the subscriber reads from the channel.
+	// Delivery to all subscriptions stops while waiting.
+	SlowConsumerBlock
+)
+
+// AsyncPubSub receives Pub/Sub notifications on a dedicated connection and
+// delivers them on Go channels. Subscriptions can be added and removed while
+// notifications are delivered.
+//
+//  ps := &redis.AsyncPubSub{
+//    Dial: func() (redis.Conn, error) { return redis.Dial("tcp", ":6379") },
+//  }
+//  defer ps.Close()
+//  ch, err := ps.Subscribe("news")
+//  if err != nil {
+//    return err
+//  }
+//  for v := range ch {
+//    switch v := v.(type) {
+//    case redis.Message:
+//      fmt.Printf("%s: message: %s\n", v.Channel, v.Data)
+//    case redis.Subscription:
+//      fmt.Printf("%s: %s %d\n", v.Channel, v.Kind, v.Count)
+//    }
+//  }
+type AsyncPubSub struct {
+	// Dial is an application supplied function for creating the connection.
+	Dial func() (Conn, error)
+
+	// BufferSize is the capacity of the channel returned for a subscription.
+	// When zero, the capacity is 100.
+	BufferSize int
+
+	// SlowConsumer specifies how a notification is handled when the channel of
+	// a subscription is full.
+	SlowConsumer SlowConsumerPolicy
+
+	dropped int64 // atomic
+
+	// closeChan is closed by Close without holding mu to stop a blocked
+	// delivery.
+	initOnce  sync.Once
+	closeOnce sync.Once
+	closeChan chan struct{}
+
+	// mu protects fields defined below.
+	mu       sync.Mutex
+	c        Conn
+	err      error
+	closed   bool
+	done     chan struct{}
+	channels map[string][]*pubsubSub
+	patterns map[string][]*pubsubSub
+}
+
+// pubsubSub is the subscription created by one call to Subscribe or
+// PSubscribe.
+type pubsubSub struct {
+	c chan interface{}
+
+	// quit is closed when the subscription ends to stop a blocked delivery.
+	quit chan struct{}
+
+	// mu is held while delivering to c from the receive routine. It
+	// protects ended.
+	mu    sync.Mutex
+	ended bool
+
+	// names are the channels or patterns that are still subscribed.
+	names map[string]bool
+
+	// pending are the names waiting for the server confirmation.
+	pending map[string]bool
+}
+
+// end closes the channel of the subscription. end is called with the
+// AsyncPubSub mu held.
+func (sub *pubsubSub) end() {
+	close(sub.quit)
+	sub.mu.Lock()
+	sub.ended = true
+	close(sub.c)
+	sub.mu.Unlock()
+}
+
+// Subscribe subscribes to the channels. The returned channel receives the
+// Subscription and Message notifications of the channels. The channel is
+// closed after all of the channels are unsubscribed or the connection fails.
+func (ps *AsyncPubSub) Subscribe(channel ...string) (<-chan interface{}, error) {
+	return ps.subscribe("SUBSCRIBE", "subscribe", channel)
+}
+
+// PSubscribe subscribes to the patterns. The returned channel receives the
+// Subscription and PMessage notifications of the patterns. The channel is
+// closed after all of the patterns are unsubscribed or the connection fails.
+func (ps *AsyncPubSub) PSubscribe(pattern ...string) (<-chan interface{}, error) {
+	return ps.subscribe("PSUBSCRIBE", "psubscribe", pattern)
+}
+
+// Unsubscribe unsubscribes from the channels, or from all channels if none
+// is given.
+func (ps *AsyncPubSub) Unsubscribe(channel ...string) error {
+	return ps.unsubscribe("UNSUBSCRIBE", "unsubscribe", channel)
+}
+
+// PUnsubscribe unsubscribes from the patterns, or from all patterns if none
+// is given.
+func (ps *AsyncPubSub) PUnsubscribe(pattern ...string) error {
+	return ps.unsubscribe("PUNSUBSCRIBE", "punsubscribe", pattern)
+}
+
+// Dropped returns the number of notifications discarded because of slow
+// consumers.
+func (ps *AsyncPubSub) Dropped() int64 {
+	return atomic.LoadInt64(&ps.dropped)
+}
+
+// Err returns the error that stopped the delivery of notifications.
+func (ps *AsyncPubSub) Err() error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return ps.err
+}
+
+// Close closes the connection and the channels of all subscriptions.
+func (ps *AsyncPubSub) Close() error {
+	closing := ps.closing()
+	ps.closeOnce.Do(func() { close(closing) })
+
+	ps.mu.Lock()
+	if ps.closed {
+		ps.mu.Unlock()
+		return nil
+	}
+	ps.closed = true
+	c, done := ps.c, ps.done
+	ps.mu.Unlock()
+
+	if c == nil {
+		return nil
+	}
+	err := c.Close()
+	<-done
+	return err
+}
+
+func (ps *AsyncPubSub) subscribe(cmd, kind string, names []string) (<-chan interface{}, error) {
+	if len(names) == 0 {
+		return nil, errors.New("RedisGo-Async: no channels to subscribe")
+	}
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if err := ps.start(); err != nil {
+		return nil, err
+	}
+
+	subs := ps.subs(cmd == "PSUBSCRIBE")
+	size := ps.BufferSize
+	if size <= 0 {
+		size = defaultPubSubBufferSize
+	}
+	sub := &pubsubSub{
+		c:       make(chan interface{}, size),
+		quit:    make(chan struct{}),
+		names:   make(map[string]bool),
+		pending: make(map[string]bool),
+	}
+
+	var args []interface{}
+	for _, name := range names {
+		if sub.names[name] {
+			continue
+		}
+		sub.names[name] = true
+		if len(subs[name]) == 0 {
+			sub.pending[name] = true
+			args = append(args, name)
+		} else {
+			// The connection is already subscribed, so the server does not
+			// confirm the subscription.
+			ps.deliver(sub, Subscription{Kind: kind, Channel: name, Count: ps.count()})
+		}
+		subs[name] = append(subs[name], sub)
+	}
+
+	if len(args) > 0 {
+		ps.c.Send(cmd, args...)
+		if err := ps.c.Flush(); err != nil {
+			ps.remove(subs, sub)
+			sub.end()
+			return nil, err
+		}
+	}
+	return sub.c, nil
+}
+
+// remove removes the subscription from the channels or patterns in subs.
+// remove is called with mu held.
+func (ps *AsyncPubSub) remove(subs map[string][]*pubsubSub, sub *pubsubSub) {
+	for name := range sub.names {
+		all := subs[name]
+		for i, s := range all {
+			if s == sub {
+				all = append(all[:i:i], all[i+1:]...)
+				break
+			}
+		}
+		if len(all) == 0 {
+			delete(subs, name)
+		} else {
+			subs[name] = all
+		}
+	}
+}
+
+func (ps *AsyncPubSub) unsubscribe(cmd, kind string, names []string) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if ps.closed {
+		return errPubSubClosed
+	}
+	if ps.c == nil {
+		return nil
+	}
+
+	subs := ps.subs(cmd == "PUNSUBSCRIBE")
+	if len(names) == 0 {
+		for name := range subs {
+			names = append(names, name)
+		}
+	}
+
+	var args []interface{}
+	for _, name := range names {
+		if len(subs[name]) == 0 {
+			continue
+		}
+		args = append(args, name)
+		for _, sub := range subs[name] {
+			delete(sub.names, name)
+			delete(sub.pending, name)
+		}
+		all := subs[name]
+		delete(subs, name)
+		for _, sub := range all {
+			ps.deliver(sub, Subscription{Kind: kind, Channel: name, Count: ps.count()})
+			if len(sub.names) == 0 {
+				sub.end()
+			}
+		}
+	}
+
+	if len(args) == 0 {
+		return nil
+	}
+	ps.c.Send(cmd, args...)
+	return ps.c.Flush()
+}
+
+// start dials the connection and starts the receive routine on first use.
+func (ps *AsyncPubSub) start() error {
+	if ps.closed {
+		return errPubSubClosed
+	}
+	if ps.err != nil {
+		return ps.err
+	}
+	if ps.c != nil {
+		return nil
+	}
+	if ps.Dial == nil {
+		return errors.New("RedisGo-Async: AsyncPubSub.Dial is nil")
+	}
+	c, err := ps.Dial()
+	if err != nil {
+		return err
+	}
+	ps.c = c
+	ps.done = make(chan struct{})
+	ps.channels = make(map[string][]*pubsubSub)
+	ps.patterns = make(map[string][]*pubsubSub)
+	go ps.receive(c, ps.closing(), ps.done)
+	return nil
+}
+
+func (ps *AsyncPubSub) closing() chan struct{} {
+	ps.initOnce.Do(func() { ps.closeChan = make(chan struct{}) })
+	return ps.closeChan
+}
+
+func (ps *AsyncPubSub) subs(pattern bool) map[string][]*pubsubSub {
+	if pattern {
+		return ps.patterns
+	}
+	return ps.channels
+}
+
+// count returns the number of channels and patterns of the connection.
+func (ps *AsyncPubSub) count() int {
+	return len(ps.channels) + len(ps.patterns)
+}
+
+// receive reads notifications until the connection fails.
+func (ps *AsyncPubSub) receive(c Conn, closeChan chan struct{}, done chan struct{}) {
+	defer close(done)
+	psc := PubSubConn{Conn: c}
+	for {
+		v := psc.Receive()
+		if err, ok := v.(error); ok {
+			if c.Err() == nil {
+				// Error reply to a command, the connection is still usable.
+				continue
+			}
+			ps.fail(err)
+			return
+		}
+		ps.dispatch(v, closeChan)
+	}
+}
+
+func (ps *AsyncPubSub) dispatch(v interface{}, closeChan chan struct{}) {
+	ps.mu.Lock()
+	var subs []*pubsubSub
+	switch v := v.(type) {
+	case Message:
+		subs = append(subs, ps.channels[v.Channel]...)
+	case PMessage:
+		subs = append(subs, ps.patterns[v.Pattern]...)
+	case Subscription:
+		all := ps.channels
+		if v.Kind == "psubscribe" {
+			all = ps.patterns
+		} else if v.Kind != "subscribe" {
+			// Unsubscriptions are delivered when requested.
+			break
+		}
+		for _, sub := range all[v.Channel] {
+			if sub.pending[v.Channel] {
+				delete(sub.pending, v.Channel)
+				subs = append(subs, sub)
+			}
+		}
+	}
+	ps.mu.Unlock()
+
+	// A blocked delivery must not hold mu, or a slow subscriber stops
+	// Subscribe, Unsubscribe and Err of all subscribers.
+	for _, sub := range subs {
+		ps.deliverWait(sub, v, closeChan)
+	}
+}
+
+// deliver sends v to the subscription without blocking.
+func (ps *AsyncPubSub) deliver(sub *pubsubSub, v interface{}) {
+	select {
+	case sub.c <- v:
+	default:
+		atomic.AddInt64(&ps.dropped, 1)
+	}
+}
+
+// deliverWait sends v to the subscription using the slow consumer policy.
+func (ps *AsyncPubSub) deliverWait(sub *pubsubSub, v interface{}, closeChan chan struct{}) {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	if sub.ended {
+		return
+	}
+
+	select {
+	case sub.c <- v:
+		return
+	default:
+	}
+
+	switch ps.SlowConsumer {
+	case SlowConsumerDropOldest:
+		select {
+		case <-sub.c:
+			atomic.AddInt64(&ps.dropped, 1)
+		default:
+		}
+		ps.deliver(sub, v)
+	case SlowConsumerBlock:
+		select {
+		case sub.c <- v:
+		case <-sub.quit:
+		case <-closeChan:
+		}
+	default:
+		atomic.AddInt64(&ps.dropped, 1)
+	}
+}
+
+// fail closes the channels of all subscriptions.
+func (ps *AsyncPubSub) fail(err error) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if ps.closed {
+		err = errPubSubClosed
+	}
+	ps.err = err
+	ended := make(map[*pubsubSub]bool)
+	for _, subs := range []map[string][]*pubsubSub{ps.channels, ps.patterns} {
+		for _, all := range subs {
+			for _, sub := range all {
+				if !ended[sub] {
+					ended[sub] = true
+					sub.end()
+				}
+			}
+		}
+	}
+	ps.channels = nil
+	ps.patterns = nil
+}
@@ -0,0 +1,266 @@
+// Copyright 2017 xiaofei, gistao
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package redis_test
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gistao/RedisGo-Async/redis"
+)
+
+// fakePubSubServer confirms subscriptions and then sends n messages for each
+// subscribed channel or pattern.
+func fakePubSubServer(n int) *fakeServer {
+	count := 0
+	return &fakeServer{buffered: true, handler: func(args []string) string {
+		var reply string
+		kind := strings.ToLower(args[0])
+		switch args[0] {
+		case "SUBSCRIBE", "PSUBSCRIBE":
+			for _, name := range args[1:] {
+				count++
+				reply += "*3\r\n" + bulk(kind) + bulk(name) + ":" + strconv.Itoa(count) + "\r\n"
+			}
+			for _, name := range args[1:] {
+				for i := 0; i < n; i++ {
+					if kind == "subscribe" {
+						reply += "*3\r\n" + bulk("message") + bulk(name) + bulk(strconv.Itoa(i))
+					} else {
+						reply += "*4\r\n" + bulk("pmessage") + bulk(name) + bulk(name+"x") + bulk(strconv.Itoa(i))
+					}
+				}
+			}
+		case "UNSUBSCRIBE", "PUNSUBSCRIBE":
+			for _, name := range args[1:] {
+				count--
+				reply += "*3\r\n" + bulk(kind) + bulk(name) + ":" + strconv.Itoa(count) + "\r\n"
+			}
+		}
+		return reply
+	}}
+}
+
+func receiveN(t *testing.T, ch <-chan interface{}, n int) []interface{} {
+	var got []interface{}
+	for i := 0; i < n; i++ {
+		select {
+		case v, ok := <-ch:
+			if !ok {
+				t.Fatalf("channel closed after %d values", len(got))
+			}
+			got = append(got, v)
+		case <-time.After(time.Second):
+			t.Fatalf("timeout after %d values", len(got))
+		}
+	}
+	return got
+}
+
+func TestAsyncPubSub(t *testing.T) {
+	ps := &redis.AsyncPubSub{Dial: fakePubSubServer(1).dial()}
+	defer ps.Close()
+
+	ch, err := ps.Subscribe("a", "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := receiveN(t, ch, 4)
+	want := []interface{}{
+		redis.Subscription{Kind: "subscribe", Channel: "a", Count: 1},
+		redis.Subscription{Kind: "subscribe", Channel: "b", Count: 2},
+		redis.Message{Channel: "a", Data: []byte("0")},
+		redis.Message{Channel: "b", Data: []byte("0")},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Subscribe delivered %v, want %v", got, want)
+	}
+
+	pch, err := ps.PSubscribe("p*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = receiveN(t, pch, 2)
+	want = []interface{}{
+		redis.Subscription{Kind: "psubscribe", Channel: "p*", Count: 3},
+		redis.PMessage{Pattern: "p*", Channel: "p*x", Data: []byte("0")},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("PSubscribe delivered %v, want %v", got, want)
+	}
+
+	if err := ps.Unsubscribe("a"); err != nil {
+		t.Fatal(err)
+	}
+	if got := receiveN(t, ch, 1)[0]; got != (redis.Subscription{Kind: "unsubscribe", Channel: "a", Count: 2}) {
+		t.Errorf("Unsubscribe(a) delivered %v", got)
+	}
+	if err := ps.Unsubscribe(); err != nil {
+		t.Fatal(err)
+	}
+	receiveN(t, ch, 1)
+	if _, ok := <-ch; ok {
+		t.Error("channel open after all channels were unsubscribed")
+	}
+
+	ps.Close()
+	if _, ok := <-pch; ok {
+		t.Error("channel open after Close")
+	}
+}
+
+func TestAsyncPubSubSlowConsumer(t *testing.T) {
+	for _, tt := range []struct {
+		policy redis.SlowConsumerPolicy
+		want   interface{}
+	}{
+		{redis.SlowConsumerDropNewest, redis.Subscription{Kind: "subscribe", Channel: "c", Count: 1}},
+		{redis.SlowConsumerDropOldest, redis.Message{Channel: "c", Data: []byte("2")}},
+	} {
+		ps := &redis.AsyncPubSub{Dial: fakePubSubServer(3).dial()}
+		ps.BufferSize = 1
+		ps.SlowConsumer = tt.policy
+
+		ch, err := ps.Subscribe("c")
+		if err != nil {
+			t.Fatal(err)
+		}
+		deadline := time.Now().Add(time.Second)
+		for ps.Dropped() != 3 {
+			if time.Now().After(deadline) {
+				t.Fatalf("policy %d: Dropped() = %d, want 3", tt.policy, ps.Dropped())
+			}
+			time.Sleep(time.Millisecond)
+		}
+		if got := receiveN(t, ch, 1)[0]; !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("policy %d: delivered %v, want %v", tt.policy, got, tt.want)
+		}
+		ps.Close()
+	}
+}
+
+func TestAsyncPubSubBlockClose(t *testing.T) {
+	ps := &redis.AsyncPubSub{Dial: fakePubSubServer(3).dial()}
+	ps.BufferSize = 1
+	ps.SlowConsumer = redis.SlowConsumerBlock
+
+	if _, err := ps.Subscribe("c"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	closed := make(chan struct{})
+	go func() {
+		ps.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close blocked by slow consumer")
+	}
+}
+
+func TestAsyncPubSubBlockUnsubscribe(t *testing.T) {
+	ps := &redis.AsyncPubSub{
+		Dial:         fakePubSubServer(3).dial(),
+		BufferSize:   1,
+		SlowConsumer: redis.SlowConsumerBlock,
+	}
+	defer ps.Close()
+
+	ch, err := ps.Subscribe("c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The subscription fills the buffer and the delivery of the first
+	// message blocks.
+	time.Sleep(10 * time.Millisecond)
+
+	unsubscribed := make(chan error, 1)
+	go func() { unsubscribed <- ps.Unsubscribe("c") }()
+	select {
+	case err := <-unsubscribed:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Unsubscribe blocked by slow consumer")
+	}
+	if err := ps.Err(); err != nil {
+		t.Fatalf("Err() returned %v", err)
+	}
+	for range ch {
+	}
+
+	ch, err = ps.Subscribe("d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := receiveN(t, ch, 1)[0]; got != (redis.Subscription{Kind: "subscribe", Channel: "d", Count: 1}) {
+		t.Errorf("Subscribe(d) delivered %v", got)
+	}
+}
+
+// flushErrConn fails Flush while fail is set.
+type flushErrConn struct {
+	redis.Conn
+	fail *int32
+}
+
+func (c flushErrConn) Flush() error {
+	if atomic.LoadInt32(c.fail) != 0 {
+		return errors.New("flush failed")
+	}
+	return c.Conn.Flush()
+}
+
+func TestAsyncPubSubFlushError(t *testing.T) {
+	var fail int32
+	dial := fakePubSubServer(1).dial()
+	ps := &redis.AsyncPubSub{Dial: func() (redis.Conn, error) {
+		c, err := dial()
+		return flushErrConn{Conn: c, fail: &fail}, err
+	}}
+	defer ps.Close()
+
+	a, err := ps.Subscribe("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiveN(t, a, 2)
+
+	atomic.StoreInt32(&fail, 1)
+	if ch, err := ps.Subscribe("b"); err == nil || ch != nil {
+		t.Fatalf("Subscribe(b) returned %v, %v, want nil channel and error", ch, err)
+	}
+
+	// The failed subscription is not registered, so the next Subscribe
+	// sends SUBSCRIBE again.
+	atomic.StoreInt32(&fail, 0)
+	b, err := ps.Subscribe("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := receiveN(t, b, 2)
+	if _, ok := got[1].(redis.Message); !ok {
+		t.Errorf("Subscribe(b) received %v, want a subscription and a message", got)
+	}
+}
@@ -0,0 +1,694 @@
+// Copyright 2017 xiaofei, gistao
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package redis
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gistao/RedisGo-Async/internal"
+)
+
+const (
+	defaultCacheMaxEntries = 10000
+	invalidateChannel      = "__redis__:invalidate"
+)
+
+var (
+	errCacheClosed  = errors.New("RedisGo-Async: cache closed")
+	errTrackingLost = errors.New("RedisGo-Async: cache invalidation connection lost")
+)
+
+// uncachedCommands are read-only commands with replies that change without
+// a modification of the key.
+var uncachedCommands = map[string]bool{
+	"HRANDFIELD":  true,
+	"OBJECT":      true,
+	"PTTL":        true,
+	"SORT_RO":     true,
+	"SRANDMEMBER": true,
+	"TTL":         true,
+	"ZRANDMEMBER": true,
+}
+
+// Cache is a local cache of replies to read-only commands that uses
+// server-assisted client side caching to evict stale replies. See
+// https://redis.io/docs/manual/client-side-caching/ for information on client
+// side caching in Redis.
+//
+// Connections used with the cache are dialed with the function returned by
+// Dial or AsyncDial, which enables CLIENT TRACKING on the connection. The
+// server then sends an invalidation message when a key read on the
+// connection is modified and the cache evicts the replies for the key.
+//
+// The invalidation messages are redirected to a dedicated connection dialed
+// with RedirectDial and subscribed to the __redis__:invalidate channel. The
+// cache reads the messages as they arrive, so replies are evicted even when
+// the connections that read them are idle:
+//
+//  cache := &redis.Cache{
+//    RedirectDial: func() (redis.Conn, error) { return redis.Dial("tcp", addr) },
+//  }
+//  pool := &redis.Pool{
+//    Dial: cache.Dial(func() (redis.Conn, error) { return redis.Dial("tcp", addr) }),
+//  }
+//
+//  c := pool.Get()
+//  defer c.Close()
+//  v, err := cache.Do(c, "GET", "key")
+//
+// The replies read on a connection are evicted when the connection is
+// closed, and all replies are evicted when the invalidation connection is
+// lost. Async connections used with the cache must not be dialed with
+// DialReconnect because tracking is not enabled again after a redial.
+//
+// Replies returned from the cache are shared and must not be modified.
+type Cache struct {
+
+	// Maximum number of cached replies. The least recently used reply is
+	// evicted when the limit is reached. When zero, the limit is 10000.
+	MaxEntries int
+
+	// RedirectDial is an application supplied function for creating the
+	// connection that receives invalidation messages.
+	RedirectDial func() (Conn, error)
+
+	// subMu protects the invalidation connection. It is acquired before mu.
+	subMu    sync.Mutex
+	sub      Conn
+	clientID int64
+
+	// nextID is the id of the next tracking connection. Accessed with atomic
+	// operations.
+	nextID uint64
+
+	// mu protects fields defined below.
+	mu     sync.Mutex
+	closed bool
+	gen    uint64
+
+	// Cached replies with the most recently used at the front.
+	lru     list.List
+	entries map[string]*list.Element
+	byKey   map[string]map[*list.Element]bool
+	byConn  map[uint64]map[*list.Element]bool
+
+	// Reads in progress by key. A read stores its reply only if the token
+	// for the key was not replaced by an invalidation in the meantime.
+	pending map[string]*int
+}
+
+type cacheEntry struct {
+	id    string
+	key   string
+	conn  uint64
+	value interface{}
+}
+
+// Dial returns a dial function for Pool.Dial that enables tracking on every
+// connection returned by dial.
+func (c *Cache) Dial(dial func() (Conn, error)) func() (Conn, error) {
+	return func() (Conn, error) {
+		conn, err := dial()
+		if err != nil {
+			return nil, err
+		}
+		id, gen, err := c.track(conn)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return &cacheConn{Conn: conn, cache: c, id: id, gen: gen}, nil
+	}
+}
+
+// AsyncDial returns a dial function for AsyncPool.Dial that enables tracking
+// on every connection returned by dial.
+func (c *Cache) AsyncDial(dial func() (AsynConn, error)) func() (AsynConn, error) {
+	return func() (AsynConn, error) {
+		conn, err := dial()
+		if err != nil {
+			return nil, err
+		}
+		id, gen, err := c.track(conn)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return &cacheAsynConn{AsynConn: conn, cache: c, id: id, gen: gen}, nil
+	}
+}
+
+// track enables tracking on conn and registers the connection.
+func (c *Cache) track(conn interface {
+	Do(string, ...interface{}) (interface{}, error)
+}) (uint64, uint64, error) {
+	if c.RedirectDial == nil {
+		return 0, 0, errors.New("RedisGo-Async: Cache.RedirectDial is nil")
+	}
+	clientID, gen, err := c.subscribe()
+	if err != nil {
+		return 0, 0, err
+	}
+	do := conn.Do
+	if conn, ok := conn.(AsynConn); ok {
+		// Async connections reject CLIENT TRACKING from shared callers.
+		do = func(cmd string, args ...interface{}) (interface{}, error) {
+			return doSetup(conn, cmd, args...)
+		}
+	}
+	if _, err := do("CLIENT", "TRACKING", "ON", "REDIRECT", clientID); err != nil {
+		return 0, 0, err
+	}
+
+	id := atomic.AddUint64(&c.nextID, 1)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return 0, 0, errCacheClosed
+	}
+	if c.byConn == nil {
+		c.byConn = make(map[uint64]map[*list.Element]bool)
+	}
+	c.byConn[id] = make(map[*list.Element]bool)
+	return id, gen, nil
+}
+
+// untrack evicts the replies read on the tracking connection id.
+func (c *Cache) untrack(id uint64) {
+	c.mu.Lock()
+	c.untrackLocked(id)
+	c.mu.Unlock()
+}
+
+func (c *Cache) untrackLocked(id uint64) {
+	for e := range c.byConn[id] {
+		c.removeLocked(e)
+	}
+	delete(c.byConn, id)
+}
+
+// subscribe returns the client id of the invalidation connection and the
+// generation of the cache, dialing the connection if it is not running.
+func (c *Cache) subscribe() (int64, uint64, error) {
+	c.subMu.Lock()
+	defer c.subMu.Unlock()
+
+	if c.sub == nil {
+		if c.isClosed() {
+			return 0, 0, errCacheClosed
+		}
+		conn, err := c.RedirectDial()
+		if err != nil {
+			return 0, 0, err
+		}
+		clientID, err := c.startSubscriber(conn)
+		if err != nil {
+			conn.Close()
+			return 0, 0, err
+		}
+		c.sub = conn
+		c.clientID = clientID
+		go c.listen(conn)
+	}
+	return c.clientID, c.generation(), nil
+}
+
+// startSubscriber subscribes conn to the invalidation channel and returns the
+// client id of the connection.
+func (c *Cache) startSubscriber(conn Conn) (int64, error) {
+	clientID, err := Int64(conn.Do("CLIENT", "ID"))
+	if err != nil {
+		return 0, err
+	}
+	conn.Send("SUBSCRIBE", invalidateChannel)
+	if err := conn.Flush(); err != nil {
+		return 0, err
+	}
+	if _, err := conn.Receive(); err != nil {
+		return 0, err
+	}
+	return clientID, nil
+}
+
+// listen handles the messages of the invalidation connection. When the
+// connection is lost, all replies are evicted and the connections tracking
+// keys with this connection report an error.
+func (c *Cache) listen(conn Conn) {
+	for {
+		reply, err := conn.Receive()
+		if err != nil {
+			break
+		}
+		var m []interface{}
+		switch reply := reply.(type) {
+		case []interface{}:
+			m = reply
+		case Push:
+			m = reply
+		}
+		if len(m) == 3 {
+			kind, _ := String(m[0], nil)
+			channel, _ := String(m[1], nil)
+			if kind == "message" && channel == invalidateChannel {
+				c.invalidate(m[2])
+			}
+		}
+	}
+	conn.Close()
+
+	c.subMu.Lock()
+	if c.sub == conn {
+		c.sub = nil
+	}
+	c.mu.Lock()
+	c.gen++
+	c.flushLocked()
+	c.mu.Unlock()
+	c.subMu.Unlock()
+}
+
+// invalidate evicts the replies for the keys of an invalidation message. A
+// nil list of keys evicts all replies.
+func (c *Cache) invalidate(keys interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ks, ok := keys.([]interface{})
+	if !ok {
+		c.flushLocked()
+		return
+	}
+	for _, k := range ks {
+		if key, err := String(k, nil); err == nil {
+			c.evictKeyLocked(key)
+		}
+	}
+}
+
+func (c *Cache) evictKeyLocked(key string) {
+	for e := range c.byKey[key] {
+		c.removeLocked(e)
+	}
+	delete(c.pending, key)
+}
+
+func (c *Cache) flushLocked() {
+	c.lru.Init()
+	c.entries = nil
+	c.byKey = nil
+	c.pending = nil
+	for id := range c.byConn {
+		c.byConn[id] = make(map[*list.Element]bool)
+	}
+}
+
+func (c *Cache) removeLocked(e *list.Element) {
+	entry := c.lru.Remove(e).(*cacheEntry)
+	delete(c.entries, entry.id)
+	if m := c.byKey[entry.key]; m != nil {
+		delete(m, e)
+		if len(m) == 0 {
+			delete(c.byKey, entry.key)
+		}
+	}
+	delete(c.byConn[entry.conn], e)
+}
+
+func (c *Cache) generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.gen
+}
+
+func (c *Cache) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+// Len returns the number of cached replies.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// Close closes the invalidation connection and evicts all replies.
+// Connections dialed with the cache report an error after Close.
+func (c *Cache) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	c.gen++
+	c.flushLocked()
+	c.mu.Unlock()
+
+	c.subMu.Lock()
+	sub := c.sub
+	c.sub = nil
+	c.subMu.Unlock()
+	if sub != nil {
+		return sub.Close()
+	}
+	return nil
+}
+
+// Do executes the command on conn. The reply to a read-only command with one
+// key is returned from the cache when present and cached otherwise. Writes
+// evict the replies for the written keys, so that the application reads its
+// own writes before the invalidation message arrives.
+func (c *Cache) Do(conn Conn, commandName string, args ...interface{}) (interface{}, error) {
+	r := c.begin(conn, commandName, args)
+	if r.hit {
+		return r.value, nil
+	}
+	v, err := conn.Do(commandName, args...)
+	r.end(v, err, conn.Err())
+	return v, err
+}
+
+// AsyncDo acts like Do on an async connection.
+func (c *Cache) AsyncDo(conn AsynConn, commandName string, args ...interface{}) (AsyncRet, error) {
+	if cp, ok := conn.(connPicker); ok {
+		// Send the command on one pool connection, so that the reply is
+		// tracked by the connection that read it.
+		pc, err := cp.pickConn(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		conn = pc
+	}
+	r := c.begin(conn, commandName, args)
+	if r.hit {
+		return cachedRet{r.value}, nil
+	}
+	ret, err := conn.AsyncDo(commandName, args...)
+	if err != nil {
+		r.end(nil, err, conn.Err())
+		return nil, err
+	}
+	return &cacheAsyncRet{r: r, conn: conn, ret: ret}, nil
+}
+
+// cacheRead is a command executed with the cache.
+type cacheRead struct {
+	c     *Cache
+	id    string
+	key   string
+	conn  uint64
+	token *int
+
+	hit   bool
+	value interface{}
+}
+
+// begin looks up the reply to the command. If the reply is not cached and
+// the command is cacheable, then begin registers the read.
+func (c *Cache) begin(conn interface{}, commandName string, args []interface{}) *cacheRead {
+	r := &cacheRead{c: c}
+	ci := internal.LookupCommandInfo(commandName)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return r
+	}
+
+	if ci.Flags&internal.WriteFlag != 0 {
+		for _, key := range commandKeys(ci, args) {
+			c.evictKeyLocked(key)
+		}
+		return r
+	}
+
+	key, ok := cachedKey(commandName, ci, args)
+	if !ok {
+		return r
+	}
+	r.id = cacheID(commandName, args)
+	if e, ok := c.entries[r.id]; ok {
+		c.lru.MoveToFront(e)
+		r.hit = true
+		r.value = e.Value.(*cacheEntry).value
+		return r
+	}
+
+	id, ok := trackingID(conn)
+	if !ok {
+		return r
+	}
+	r.key = key
+	r.conn = id
+	if c.pending == nil {
+		c.pending = make(map[string]*int)
+	}
+	r.token = c.pending[key]
+	if r.token == nil {
+		r.token = new(int)
+		c.pending[key] = r.token
+	}
+	*r.token++
+	return r
+}
+
+// end stores the reply of a registered read if the command and the
+// connection did not fail and the key was not invalidated during the read.
+// The replies read on a failed connection are evicted, because the
+// invalidation messages for these replies may be lost.
+func (r *cacheRead) end(value interface{}, err, connErr error) {
+	if r.token == nil {
+		return
+	}
+	c := r.c
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	token := r.token
+	r.token = nil
+	if c.pending[r.key] == token {
+		if *token--; *token == 0 {
+			delete(c.pending, r.key)
+		}
+	} else {
+		err = errTrackingLost
+	}
+
+	if connErr != nil {
+		c.untrackLocked(r.conn)
+		return
+	}
+	conn, ok := c.byConn[r.conn]
+	if err != nil || !ok || c.closed {
+		return
+	}
+	if e, ok := c.entries[r.id]; ok {
+		c.removeLocked(e)
+	}
+
+	e := c.lru.PushFront(&cacheEntry{id: r.id, key: r.key, conn: r.conn, value: value})
+	if c.entries == nil {
+		c.entries = make(map[string]*list.Element)
+	}
+	c.entries[r.id] = e
+	if c.byKey == nil {
+		c.byKey = make(map[string]map[*list.Element]bool)
+	}
+	if c.byKey[r.key] == nil {
+		c.byKey[r.key] = make(map[*list.Element]bool)
+	}
+	c.byKey[r.key][e] = true
+	conn[e] = true
+
+	max := c.MaxEntries
+	if max <= 0 {
+		max = defaultCacheMaxEntries
+	}
+	for c.lru.Len() > max {
+		c.removeLocked(c.lru.Back())
+	}
+}
+
+// cachedKey returns the key of a command with a cacheable reply.
+func cachedKey(commandName string, ci internal.CommandInfo, args []interface{}) (string, bool) {
+	if ci.Flags&internal.ReadOnlyFlag == 0 ||
+		ci.Flags&(internal.BlockingFlag|internal.MovableKeysFlag) != 0 ||
+		ci.FirstKey <= 0 || ci.LastKey != ci.FirstKey ||
+		uncachedCommands[strings.ToUpper(commandName)] {
+		return "", false
+	}
+	keys := commandKeys(ci, args)
+	if len(keys) != 1 {
+		return "", false
+	}
+	return keys[0], true
+}
+
+// commandKeys returns the keys of a command with fixed key positions.
+func commandKeys(ci internal.CommandInfo, args []interface{}) []string {
+	if ci.FirstKey <= 0 || ci.Flags&internal.MovableKeysFlag != 0 {
+		return nil
+	}
+	last := ci.LastKey - 1
+	if ci.LastKey < 0 {
+		last = len(args) + ci.LastKey
+	}
+	step := ci.Step
+	if step <= 0 {
+		step = 1
+	}
+	var keys []string
+	for i := ci.FirstKey - 1; i <= last && i < len(args); i += step {
+		keys = append(keys, internal.ArgString(args[i]))
+	}
+	return keys
+}
+
+// cacheID returns the cache id of a command with arguments.
+func cacheID(commandName string, args []interface{}) string {
+	var b strings.Builder
+	b.WriteString(strings.ToUpper(commandName))
+	for _, arg := range args {
+		s := internal.ArgString(arg)
+		b.WriteByte(' ')
+		b.WriteString(strconv.Itoa(len(s)))
+		b.WriteByte(':')
+		b.WriteString(s)
+	}
+	return b.String()
+}
+
+// trackingID returns the id of the tracking connection underlying conn.
+func trackingID(conn interface{}) (uint64, bool) {
+	switch conn := conn.(type) {
+	case *cacheConn:
+		return conn.id, true
+	case *cacheAsynConn:
+		return conn.id, true
+	case *pooledConnection:
+		return trackingID(conn.c)
+	case *asyncPoolConnection:
+		return trackingID(conn.c)
+	}
+	return 0, false
+}
+
+// cacheConn is a connection dialed by Cache.Dial.
+type cacheConn struct {
+	Conn
+	cache *Cache
+	id    uint64
+	gen   uint64
+}
+
+func (c *cacheConn) Err() error {
+	if c.cache.generation() != c.gen {
+		return errTrackingLost
+	}
+	return c.Conn.Err()
+}
+
+func (c *cacheConn) Close() error {
+	c.cache.untrack(c.id)
+	return c.Conn.Close()
+}
+
+// cacheAsynConn is an async connection dialed by Cache.AsyncDial.
+type cacheAsynConn struct {
+	AsynConn
+	cache *Cache
+	id    uint64
+	gen   uint64
+}
+
+func (c *cacheAsynConn) Err() error {
+	if c.cache.generation() != c.gen {
+		return errTrackingLost
+	}
+	return c.AsynConn.Err()
+}
+
+func (c *cacheAsynConn) Close() error {
+	c.cache.untrack(c.id)
+	return c.AsynConn.Close()
+}
+
+func (c *cacheAsynConn) CloseGraceful(ctx context.Context) error {
+	c.cache.untrack(c.id)
+	return closeGraceful(ctx, c.AsynConn)
+}
+
+func (c *cacheAsynConn) DoContext(ctx context.Context, commandName string, args ...interface{}) (interface{}, error) {
+	return doContext(ctx, c.AsynConn, commandName, args)
+}
+
+func (c *cacheAsynConn) AsyncDoContext(ctx context.Context, commandName string, args ...interface{}) (AsyncRet, error) {
+	return asyncDoContext(ctx, c.AsynConn, commandName, args)
+}
+
+func (c *cacheAsynConn) QueueLen() int {
+	return queueLen(c.AsynConn)
+}
+
+func (c *cacheAsynConn) AsyncTx(cmds ...Command) (AsyncRet, error) {
+	return asyncTxContext(context.Background(), c.AsynConn, cmds)
+}
+
+func (c *cacheAsynConn) AsyncTxContext(ctx context.Context, cmds ...Command) (AsyncRet, error) {
+	return asyncTxContext(ctx, c.AsynConn, cmds)
+}
+
+func (c *cacheAsynConn) lastActive() time.Time {
+	return lastActive(c.AsynConn)
+}
+
+func (c *cacheAsynConn) inflightCount() int {
+	return inflightCount(c.AsynConn)
+}
+
+// cachedRet is the result of a command returned from the cache.
+type cachedRet struct {
+	value interface{}
+}
+
+func (r cachedRet) Get() (interface{}, error) {
+	return r.value, nil
+}
+
+func (r cachedRet) GetContext(ctx context.Context) (interface{}, error) {
+	return r.value, nil
+}
+
+// cacheAsyncRet stores the reply of an async command in the cache.
+type cacheAsyncRet struct {
+	r    *cacheRead
+	conn AsynConn
+	ret  AsyncRet
+}
+
+func (r *cacheAsyncRet) Get() (interface{}, error) {
+	return r.GetContext(context.Background())
+}
+
+func (r *cacheAsyncRet) GetContext(ctx context.Context) (interface{}, error) {
+	v, err := getContext(ctx, r.ret)
+	r.r.end(v, err, r.conn.Err())
+	return v, err
+}
@@ -0,0 +1,207 @@
+// Copyright 2017 xiaofei, gistao
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package redis_test
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gistao/RedisGo-Async/redis"
+)
+
+// newFakeTrackingServer returns a server that counts GET commands and writes
+// an invalidation message to messages when a client sets a key.
+func newFakeTrackingServer(gets *int, messages chan string) *fakeServer {
+	return &fakeServer{handler: func(args []string) string {
+		switch args[0] {
+		case "CLIENT":
+			if got := strings.Join(args, " "); got != "CLIENT TRACKING ON REDIRECT 7" {
+				return "-ERR got " + got + "\r\n"
+			}
+			return "+OK\r\n"
+		case "GET":
+			*gets++
+			return bulk("v" + strconv.Itoa(*gets))
+		case "SET":
+			messages <- "*3\r\n" + bulk("message") + bulk("__redis__:invalidate") + "*1\r\n" + bulk(args[1])
+			return "+OK\r\n"
+		}
+		return "-ERR unknown command\r\n"
+	}}
+}
+
+// newFakeCache returns a cache with the invalidation connection served by
+// serveInvalidations.
+func newFakeCache(messages chan string) *redis.Cache {
+	return &redis.Cache{
+		RedirectDial: func() (redis.Conn, error) {
+			return redis.Dial("", "", dialPipe(func(c net.Conn) { serveInvalidations(c, messages) }))
+		},
+	}
+}
+
+func expectCached(t *testing.T, v interface{}, err error, want string) {
+	t.Helper()
+	if s, err := redis.String(v, err); err != nil || s != want {
+		t.Fatalf("got %q, %v, want %q", s, err, want)
+	}
+}
+
+func TestCache(t *testing.T) {
+	gets := 0
+	messages := make(chan string, 10)
+	s := newFakeTrackingServer(&gets, messages)
+	cache := newFakeCache(messages)
+	defer cache.Close()
+	p := &redis.Pool{
+		MaxIdle: 1,
+		Dial:    cache.Dial(s.dial()),
+	}
+	defer p.Close()
+
+	c := p.Get()
+	v, err := cache.Do(c, "GET", "k")
+	expectCached(t, v, err, "v1")
+	v, err = cache.Do(c, "GET", "k")
+	expectCached(t, v, err, "v1")
+	if gets != 1 {
+		t.Errorf("server received %d GET commands, want 1", gets)
+	}
+
+	// A write by another client evicts the reply while the tracking
+	// connection is idle.
+	other, err := s.dial()()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if _, err := other.Do("SET", "k", "x"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "invalidation", func() bool { return cache.Len() == 0 })
+	v, err = cache.Do(c, "GET", "k")
+	expectCached(t, v, err, "v2")
+
+	// Writes with the cache evict the keys immediately.
+	if _, err := cache.Do(c, "SET", "k", "y"); err != nil {
+		t.Fatal(err)
+	}
+	if n := cache.Len(); n != 0 {
+		t.Errorf("Len() = %d after write, want 0", n)
+	}
+
+	// Closing the connection evicts the replies read on it.
+	v, err = cache.Do(c, "GET", "j")
+	expectCached(t, v, err, "v3")
+	c.Close()
+	if n := cache.Len(); n != 1 {
+		t.Errorf("Len() = %d with idle connection, want 1", n)
+	}
+	p.Close()
+	if n := cache.Len(); n != 0 {
+		t.Errorf("Len() = %d after closing the connection, want 0", n)
+	}
+}
+
+func TestCacheAsync(t *testing.T) {
+	gets := 0
+	messages := make(chan string, 10)
+	s := newFakeTrackingServer(&gets, messages)
+	cache := newFakeCache(messages)
+	defer cache.Close()
+	c, err := cache.AsyncDial(s.asyncDial())()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	for i := 0; i < 2; i++ {
+		ret, err := cache.AsyncDo(c, "GET", "k")
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, err := ret.Get()
+		expectCached(t, v, err, "v1")
+	}
+
+	other := asyncDialFake(t, s)
+	defer other.Close()
+	if _, err := other.Do("SET", "k", "x"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "invalidation", func() bool { return cache.Len() == 0 })
+	ret, err := cache.AsyncDo(c, "GET", "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := ret.Get()
+	expectCached(t, v, err, "v2")
+}
+
+func TestCacheInvalidationLost(t *testing.T) {
+	gets := 0
+	messages := make(chan string, 10)
+	s := newFakeTrackingServer(&gets, messages)
+	cache := newFakeCache(messages)
+	defer cache.Close()
+	p := &redis.Pool{
+		MaxIdle: 1,
+		Dial:    cache.Dial(s.dial()),
+	}
+	defer p.Close()
+
+	c := p.Get()
+	defer c.Close()
+	v, err := cache.Do(c, "GET", "k")
+	expectCached(t, v, err, "v1")
+
+	// Losing the invalidation connection evicts all replies and fails the
+	// connections that redirect to it.
+	close(messages)
+	waitFor(t, "connection error", func() bool { return c.Err() != nil })
+	if n := cache.Len(); n != 0 {
+		t.Errorf("Len() = %d after losing the invalidation connection, want 0", n)
+	}
+
+	if _, err := (&redis.Cache{}).Dial(s.dial())(); err == nil {
+		t.Error("Dial without RedirectDial returned nil error")
+	}
+}
+
+// serveInvalidations answers CLIENT ID and SUBSCRIBE and then writes the
+// messages until the channel is closed.
+func serveInvalidations(c net.Conn, messages chan string) {
+	defer c.Close()
+	br := bufio.NewReader(c)
+	for _, reply := range []string{
+		":7\r\n",
+		"*3\r\n" + bulk("subscribe") + bulk("__redis__:invalidate") + ":1\r\n",
+	} {
+		if _, err := readCommand(br); err != nil {
+			return
+		}
+		if _, err := c.Write([]byte(reply)); err != nil {
+			return
+		}
+	}
+	for m := range messages {
+		if _, err := c.Write([]byte(m)); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,533 @@
+// Copyright 2017 xiaofei, gistao
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gistao/RedisGo-Async/internal"
+)
+
+// ClusterSlots is the number of hash slots in a Redis Cluster.
+const ClusterSlots = 16384
+
+const defaultMaxRedirects = 16
+
+var (
+	errTooManyRedirects = errors.New("RedisGo-Async: too many cluster redirections")
+	errNoClusterNodes   = errors.New("RedisGo-Async: no reachable cluster nodes")
+)
+
+// ClusterPool routes commands to the nodes of a Redis Cluster. The pool loads
+// the slot map with CLUSTER SLOTS from the first reachable startup node, sends
+// each command to the node serving the slot of the command key and follows
+// MOVED and ASK redirections.
+//
+// The connections used by ClusterPool come from a Pool or AsyncPool per node
+// created with the NewPool and NewAsyncPool functions.
+//
+//  cluster := &redis.ClusterPool{
+//    StartupNodes: []string{"10.0.0.1:7000", "10.0.0.2:7000"},
+//    RefreshInterval: time.Minute,
+//  }
+//  defer cluster.Close()
+//  v, err := redis.String(cluster.Do("GET", "key"))
+type ClusterPool struct {
+	// StartupNodes are the addresses used to load the slot map.
+	StartupNodes []string
+
+	// NewPool is an optional application supplied function for creating the
+	// pool of a node. When nil, the pool dials the node with Dial.
+	NewPool func(addr string) *Pool
+
+	// NewAsyncPool is an optional application supplied function for creating
+	// the async pool of a node. When nil, the pool dials the node with
+	// AsyncDial.
+	NewAsyncPool func(addr string) *AsyncPool
+
+	// RefreshInterval is the interval for reloading the slot map in the
+	// background. If the value is zero, the slot map is only reloaded after a
+	// MOVED redirection.
+	RefreshInterval time.Duration
+
+	// MaxRedirects is the maximum number of redirections followed for a
+	// command. When zero, 16 redirections are followed.
+	MaxRedirects int
+
+	// startMu serializes the initial load of the slot map.
+	startMu sync.Mutex
+
+	// mu protects fields defined below.
+	mu         sync.RWMutex
+	slots      [ClusterSlots]string
+	nodes      map[string]*clusterNode
+	refreshing bool
+	closed     bool
+	closeChan  chan struct{}
+}
+
+type clusterNode struct {
+	pool      *Pool
+	asyncPool *AsyncPool
+}
+
+// Do sends a command to the node serving the command key and returns the
+// reply.
+func (p *ClusterPool) Do(commandName string, args ...interface{}) (interface{}, error) {
+	addr, err := p.addrForCommand(commandName, args)
+	if err != nil {
+		return nil, err
+	}
+	return p.do(addr, false, commandName, args)
+}
+
+func (p *ClusterPool) do(addr string, asking bool, commandName string, args []interface{}) (interface{}, error) {
+	for i := 0; i <= p.maxRedirects(); i++ {
+		pool, err := p.pool(addr)
+		if err != nil {
+			return nil, err
+		}
+		c := pool.Get()
+		if asking {
+			c.Send("ASKING")
+		}
+		reply, err := c.Do(commandName, args...)
+		c.Close()
+
+		var ok bool
+		if addr, asking, ok = p.redirect(err); !ok {
+			return reply, err
+		}
+	}
+	return nil, errTooManyRedirects
+}
+
+// AsyncDo sends a command to the node serving the command key without waiting
+// for the reply. Redirections are followed when the result is read. ASK
+// redirections are followed on a connection from the node's Pool, because
+// the ASKING command must directly precede the redirected command.
+func (p *ClusterPool) AsyncDo(commandName string, args ...interface{}) (AsyncRet, error) {
+	addr, err := p.addrForCommand(commandName, args)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := p.asyncPool(addr)
+	if err != nil {
+		return nil, err
+	}
+	ret, err := pool.Get().AsyncDo(commandName, args...)
+	if err != nil {
+		return nil, err
+	}
+	return &clusterAsyncRet{p: p, ret: ret, cmd: commandName, args: args}, nil
+}
+
+// GetForKey gets a connection to the node serving key from the node's Pool.
+// The application must close the returned connection. Use GetForKey for
+// commands that need a dedicated connection, such as SSUBSCRIBE of a shard
+// channel:
+//
+//  psc := redis.PubSubConn{Conn: cluster.GetForKey("channel")}
+//  defer psc.Close()
+//  psc.SSubscribe("channel")
+func (p *ClusterPool) GetForKey(key string) Conn {
+	addr, err := p.addrForKey(key, true)
+	if err != nil {
+		return errorConnection{err}
+	}
+	pool, err := p.pool(addr)
+	if err != nil {
+		return errorConnection{err}
+	}
+	return pool.Get()
+}
+
+// Refresh reloads the slot map from the known nodes.
+func (p *ClusterPool) Refresh() error {
+	p.mu.RLock()
+	addrs := append([]string(nil), p.StartupNodes...)
+	for addr := range p.nodes {
+		addrs = append(addrs, addr)
+	}
+	p.mu.RUnlock()
+
+	err := errNoClusterNodes
+	for _, addr := range addrs {
+		var slots [ClusterSlots]string
+		if err = p.loadSlots(addr, &slots); err == nil {
+			p.mu.Lock()
+			p.slots = slots
+			p.mu.Unlock()
+			return nil
+		}
+	}
+	return err
+}
+
+// Close releases the resources used by the pool.
+func (p *ClusterPool) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	if p.closeChan != nil {
+		close(p.closeChan)
+	}
+	nodes := p.nodes
+	p.nodes = nil
+	p.mu.Unlock()
+
+	for _, n := range nodes {
+		if n.pool != nil {
+			n.pool.Close()
+		}
+		if n.asyncPool != nil {
+			n.asyncPool.Close()
+		}
+	}
+	return nil
+}
+
+// Slot returns the hash slot of key. If the key contains a non-empty hash tag
+// enclosed in braces, only the tag is hashed.
+func Slot(key string) int {
+	if i := strings.IndexByte(key, '{'); i >= 0 {
+		if j := strings.IndexByte(key[i+1:], '}'); j > 0 {
+			key = key[i+1 : i+1+j]
+		}
+	}
+	return int(crc16(key) % ClusterSlots)
+}
+
+func (p *ClusterPool) maxRedirects() int {
+	if p.MaxRedirects > 0 {
+		return p.MaxRedirects
+	}
+	return defaultMaxRedirects
+}
+
+// addrForCommand returns the address of the node serving the command key.
+func (p *ClusterPool) addrForCommand(commandName string, args []interface{}) (string, error) {
+	key, ok := clusterKey(commandName, args)
+	return p.addrForKey(key, ok)
+}
+
+// addrForKey returns the address of the node serving key, or of any node if
+// hasKey is false.
+func (p *ClusterPool) addrForKey(key string, hasKey bool) (string, error) {
+	p.mu.RLock()
+	if p.closeChan == nil && !p.closed {
+		p.mu.RUnlock()
+		if err := p.start(); err != nil {
+			return "", err
+		}
+		p.mu.RLock()
+	}
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		return "", errPoolClosed
+	}
+	if hasKey {
+		if addr := p.slots[Slot(key)]; addr != "" {
+			return addr, nil
+		}
+	}
+	for _, addr := range p.slots {
+		if addr != "" {
+			return addr, nil
+		}
+	}
+	return "", errNoClusterNodes
+}
+
+// start loads the slot map and starts the background refresh on first use.
+func (p *ClusterPool) start() error {
+	p.startMu.Lock()
+	defer p.startMu.Unlock()
+
+	p.mu.RLock()
+	started := p.closeChan != nil
+	p.mu.RUnlock()
+	if started {
+		return nil
+	}
+
+	if err := p.Refresh(); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return errPoolClosed
+	}
+	p.closeChan = make(chan struct{})
+	if p.RefreshInterval > 0 {
+		go p.refreshLoop(p.RefreshInterval, p.closeChan)
+	}
+	return nil
+}
+
+func (p *ClusterPool) refreshLoop(interval time.Duration, closeChan chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			p.Refresh()
+		case <-closeChan:
+			return
+		}
+	}
+}
+
+// redirect parses a MOVED or ASK error and returns the address to retry.
+// MOVED updates the slot map and schedules a reload of the map.
+func (p *ClusterPool) redirect(err error) (addr string, asking bool, ok bool) {
+	e, ok := err.(Error)
+	if !ok {
+		return "", false, false
+	}
+	f := strings.Fields(string(e))
+	if len(f) != 3 || (f[0] != "MOVED" && f[0] != "ASK") {
+		return "", false, false
+	}
+	slot, err := strconv.Atoi(f[1])
+	if err != nil || slot < 0 || slot >= ClusterSlots {
+		return "", false, false
+	}
+	addr = f[2]
+
+	if f[0] == "ASK" {
+		return addr, true, true
+	}
+
+	p.mu.Lock()
+	p.slots[slot] = addr
+	refresh := !p.refreshing && !p.closed
+	p.refreshing = true
+	p.mu.Unlock()
+	if refresh {
+		go func() {
+			p.Refresh()
+			p.mu.Lock()
+			p.refreshing = false
+			p.mu.Unlock()
+		}()
+	}
+	return addr, false, true
+}
+
+// loadSlots reads the slot map from the node at addr.
+func (p *ClusterPool) loadSlots(addr string, slots *[ClusterSlots]string) error {
+	pool, err := p.pool(addr)
+	if err != nil {
+		return err
+	}
+	c := pool.Get()
+	defer c.Close()
+
+	ranges, err := Values(c.Do("CLUSTER", "SLOTS"))
+	if err != nil {
+		return err
+	}
+	host, _, _ := net.SplitHostPort(addr)
+	for _, r := range ranges {
+		v, err := Values(r, nil)
+		if err != nil {
+			return err
+		}
+		if len(v) < 3 {
+			return errors.New("RedisGo-Async: unexpected CLUSTER SLOTS reply")
+		}
+		start, err := Int(v[0], nil)
+		if err != nil {
+			return err
+		}
+		end, err := Int(v[1], nil)
+		if err != nil {
+			return err
+		}
+		master, err := Values(v[2], nil)
+		if err != nil {
+			return err
+		}
+		if len(master) < 2 || start < 0 || end >= ClusterSlots {
+			return errors.New("RedisGo-Async: unexpected CLUSTER SLOTS reply")
+		}
+		ip, err := String(master[0], nil)
+		if err != nil {
+			return err
+		}
+		port, err := Int(master[1], nil)
+		if err != nil {
+			return err
+		}
+		if ip == "" {
+			// An empty address means the node that sent the reply.
+			ip = host
+		}
+		node := net.JoinHostPort(ip, strconv.Itoa(port))
+		for i := start; i <= end; i++ {
+			slots[i] = node
+		}
+	}
+	return nil
+}
+
+// node returns the node at addr, adding it if needed. The caller must hold
+// p.mu.
+func (p *ClusterPool) node(addr string) (*clusterNode, error) {
+	if p.closed {
+		return nil, errPoolClosed
+	}
+	if p.nodes == nil {
+		p.nodes = make(map[string]*clusterNode)
+	}
+	n := p.nodes[addr]
+	if n == nil {
+		n = &clusterNode{}
+		p.nodes[addr] = n
+	}
+	return n, nil
+}
+
+// pool returns the pool for the node at addr, creating it if needed.
+func (p *ClusterPool) pool(addr string) (*Pool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n, err := p.node(addr)
+	if err != nil {
+		return nil, err
+	}
+	if n.pool == nil {
+		if p.NewPool != nil {
+			n.pool = p.NewPool(addr)
+		} else {
+			n.pool = &Pool{
+				MaxIdle: 3,
+				Dial:    func() (Conn, error) { return Dial("tcp", addr) },
+			}
+		}
+	}
+	return n.pool, nil
+}
+
+// asyncPool returns the async pool for the node at addr, creating it if
+// needed.
+func (p *ClusterPool) asyncPool(addr string) (*AsyncPool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n, err := p.node(addr)
+	if err != nil {
+		return nil, err
+	}
+	if n.asyncPool == nil {
+		if p.NewAsyncPool != nil {
+			n.asyncPool = p.NewAsyncPool(addr)
+		} else {
+			n.asyncPool = &AsyncPool{
+				Dial: func() (AsynConn, error) { return AsyncDial("tcp", addr) },
+			}
+		}
+	}
+	return n.asyncPool, nil
+}
+
+type clusterAsyncRet struct {
+	p    *ClusterPool
+	ret  AsyncRet
+	cmd  string
+	args []interface{}
+}
+
+func (r *clusterAsyncRet) Get() (interface{}, error) {
+	reply, err := r.ret.Get()
+	return r.follow(reply, err)
+}
+
+func (r *clusterAsyncRet) GetContext(ctx context.Context) (interface{}, error) {
+	reply, err := getContext(ctx, r.ret)
+	return r.follow(reply, err)
+}
+
+// follow retries the command if the reply is a redirection.
+func (r *clusterAsyncRet) follow(reply interface{}, err error) (interface{}, error) {
+	for i := 0; i <= r.p.maxRedirects(); i++ {
+		addr, asking, ok := r.p.redirect(err)
+		if !ok {
+			return reply, err
+		}
+		if asking {
+			return r.p.do(addr, true, r.cmd, r.args)
+		}
+		pool, perr := r.p.asyncPool(addr)
+		if perr != nil {
+			return nil, perr
+		}
+		reply, err = pool.Get().Do(r.cmd, r.args...)
+	}
+	return nil, errTooManyRedirects
+}
+
+// clusterKey returns the key used to route a command.
+func clusterKey(commandName string, args []interface{}) (string, bool) {
+	i := internal.KeyIndex(commandName, args)
+	if i < 0 {
+		return "", false
+	}
+	switch arg := args[i].(type) {
+	case string:
+		return arg, true
+	case []byte:
+		return string(arg), true
+	}
+	return fmt.Sprint(args[i]), true
+}
+
+var crc16Table [256]uint16
+
+func init() {
+	for i := range crc16Table {
+		crc := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+		crc16Table[i] = crc
+	}
+}
+
+// crc16 is the CRC16-CCITT (XMODEM) checksum used for cluster hash slots.
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc = crc<<8 ^ crc16Table[byte(crc>>8)^s[i]]
+	}
+	return crc
+}
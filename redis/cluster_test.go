@@ -0,0 +1,171 @@
+// Copyright 2017 xiaofei, gistao
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package redis_test
+
+import (
+	"testing"
+
+	"github.com/gistao/RedisGo-Async/redis"
+)
+
+var slotTests = []struct {
+	key  string
+	slot int
+}{
+	{"123456789", 12739},
+	{"foo", 12182},
+	{"bar", 5061},
+	{"{user1000}.following", redis.Slot("user1000")},
+	{"{user1000}.followers", redis.Slot("user1000")},
+	{"foo{}{bar}", redis.Slot("foo{}{bar}")},
+	{"foo{{bar}}zap", redis.Slot("{bar")},
+	{"foo{bar}{zap}", redis.Slot("bar")},
+}
+
+func TestSlot(t *testing.T) {
+	for _, tt := range slotTests {
+		if slot := redis.Slot(tt.key); slot != tt.slot {
+			t.Errorf("Slot(%q) = %d, want %d", tt.key, slot, tt.slot)
+		}
+	}
+	if redis.Slot("foo{}{bar}") == redis.Slot("bar") {
+		t.Errorf("Slot(%q) hashed the second tag", "foo{}{bar}")
+	}
+}
+
+const clusterSlotsReply = "*2\r\n" +
+	"*3\r\n:0\r\n:8191\r\n*2\r\n$1\r\na\r\n:1\r\n" +
+	"*3\r\n:8192\r\n:16383\r\n*2\r\n$1\r\nb\r\n:2\r\n"
+
+// newFakeCluster returns a cluster of two fake nodes. Node a:1 serves slots
+// 0-8191 and node b:2 serves slots 8192-16383. Node b redirects the keys
+// "foo" and "ask{key}" to node a.
+func newFakeCluster() *redis.ClusterPool {
+	asking := false
+	nodes := map[string]*fakeServer{
+		"a:1": {handler: func(args []string) string {
+			switch args[0] {
+			case "CLUSTER":
+				return clusterSlotsReply
+			case "ASKING":
+				asking = true
+				return "+OK\r\n"
+			}
+			if asking {
+				asking = false
+				return "+a-asked\r\n"
+			}
+			return "+a\r\n"
+		}},
+		"b:2": {handler: func(args []string) string {
+			switch {
+			case args[0] == "CLUSTER":
+				return clusterSlotsReply
+			case args[1] == "foo":
+				return "-MOVED 12182 a:1\r\n"
+			case args[1] == "ask{key}":
+				return "-ASK 12539 a:1\r\n"
+			}
+			return "+b\r\n"
+		}},
+	}
+	return &redis.ClusterPool{
+		StartupNodes: []string{"b:2"},
+		NewPool: func(addr string) *redis.Pool {
+			return &redis.Pool{
+				MaxIdle: 1,
+				Dial:    nodes[addr].dial(),
+			}
+		},
+		NewAsyncPool: func(addr string) *redis.AsyncPool {
+			return &redis.AsyncPool{
+				Dial: nodes[addr].asyncDial(),
+			}
+		},
+	}
+}
+
+var clusterTests = []struct {
+	key      string
+	expected string
+}{
+	{"bar", "a"},
+	{"key", "b"},
+	{"foo", "a"},
+	{"ask{key}", "a-asked"},
+}
+
+func TestClusterPool(t *testing.T) {
+	p := newFakeCluster()
+	defer p.Close()
+
+	for _, tt := range clusterTests {
+		v, err := redis.String(p.Do("GET", tt.key))
+		if err != nil || v != tt.expected {
+			t.Errorf("Do(GET, %q) = %q, %v, want %q", tt.key, v, err, tt.expected)
+		}
+
+		ret, err := p.AsyncDo("GET", tt.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, err = redis.String(ret.Get())
+		if err != nil || v != tt.expected {
+			t.Errorf("AsyncDo(GET, %q) = %q, %v, want %q", tt.key, v, err, tt.expected)
+		}
+	}
+}
+
+func TestClusterGetForKey(t *testing.T) {
+	p := newFakeCluster()
+	defer p.Close()
+
+	for key, expected := range map[string]string{"bar": "a", "key": "b"} {
+		c := p.GetForKey(key)
+		v, err := redis.String(c.Do("GET", key))
+		c.Close()
+		if err != nil || v != expected {
+			t.Errorf("GetForKey(%q).Do(GET) = %q, %v, want %q", key, v, err, expected)
+		}
+	}
+}
+
+func TestClusterPoolClosed(t *testing.T) {
+	p := newFakeCluster()
+	newPool := p.NewPool
+	pools := 0
+	p.NewPool = func(addr string) *redis.Pool {
+		pools++
+		return newPool(addr)
+	}
+	if _, err := p.Do("GET", "bar"); err != nil {
+		t.Fatal(err)
+	}
+	p.Close()
+	n := pools
+
+	if _, err := p.Do("GET", "bar"); err == nil {
+		t.Error("Do after Close returned nil error")
+	}
+	if _, err := p.AsyncDo("GET", "bar"); err == nil {
+		t.Error("AsyncDo after Close returned nil error")
+	}
+	if err := p.GetForKey("bar").Err(); err == nil {
+		t.Error("GetForKey after Close returned a connection without error")
+	}
+	if pools != n {
+		t.Errorf("created %d pools after Close, want 0", pools-n)
+	}
+}
@@ -0,0 +1,32 @@
+// Copyright 2017 xiaofei, gistao
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package redis
+
+import (
+	"github.com/gistao/RedisGo-Async/internal"
+)
+
+// LoadCommandInfo replaces the package's built-in command table with the
+// reply of the COMMAND command sent on c. The command table is used to find
+// the keys of commands for cluster routing and to detect blocking and
+// stateful commands. Call LoadCommandInfo once at startup when the server
+// has commands that are not in the built-in table, such as module commands.
+func LoadCommandInfo(c Conn) error {
+	reply, err := Values(c.Do("COMMAND"))
+	if err != nil {
+		return err
+	}
+	return internal.LoadCommandInfos(reply)
+}
@@ -21,6 +21,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math/big"
 	"net"
 	"net/url"
 	"regexp"
@@ -79,6 +80,17 @@ type dialOptions struct {
 	dialTLS      bool
 	skipVerify   bool
 	tlsConfig    *tls.Config
+	protocol     int
+
+	// Async connection options.
+	reconnect       bool
+	minBackoff      time.Duration
+	maxBackoff      time.Duration
+	queueSize       int
+	overflow        OverflowPolicy
+	overflowTimeout time.Duration
+	commandTimeout  time.Duration
+	livenessTimeout time.Duration
 }
 
 // DialReadTimeout specifies the timeout for reading a single command reply.
@@ -143,6 +155,68 @@ func DialTLSSkipVerify(skip bool) DialOption {
 	}}
 }
 
+// DialProtocol specifies the version of the Redis protocol used by the
+// connection. When the version is 3, Dial negotiates RESP3 with the HELLO
+// command and fails if the server does not support it. The default is 2.
+func DialProtocol(version int) DialOption {
+	return DialOption{func(do *dialOptions) {
+		do.protocol = version
+	}}
+}
+
+// DialReconnect specifies that an async connection redials the server after a
+// fatal error. Redial attempts are spaced with an exponential backoff starting
+// at minBackoff and capped at maxBackoff. Has no effect on connections
+// created with Dial.
+func DialReconnect(minBackoff, maxBackoff time.Duration) DialOption {
+	return DialOption{func(do *dialOptions) {
+		do.reconnect = true
+		do.minBackoff = minBackoff
+		do.maxBackoff = maxBackoff
+	}}
+}
+
+// DialQueueSize specifies the number of commands an async connection queues
+// before the overflow policy applies. The default is 1000. Has no effect on
+// connections created with Dial.
+func DialQueueSize(n int) DialOption {
+	return DialOption{func(do *dialOptions) {
+		do.queueSize = n
+	}}
+}
+
+// DialOverflow specifies what an async connection does with a command when
+// the queue is full. The timeout is used by the OverflowTimeout policy only.
+// Has no effect on connections created with Dial.
+func DialOverflow(policy OverflowPolicy, timeout time.Duration) DialOption {
+	return DialOption{func(do *dialOptions) {
+		do.overflow = policy
+		do.overflowTimeout = timeout
+	}}
+}
+
+// DialCommandTimeout specifies how long the caller of an async command waits
+// for the reply. A command that times out returns ErrCommandTimeout and the
+// connection remains usable. When set, DialReadTimeout is not applied to
+// the replies of async commands; use DialLivenessTimeout to detect a server
+// that stopped responding. Has no effect on connections created with Dial.
+func DialCommandTimeout(d time.Duration) DialOption {
+	return DialOption{func(do *dialOptions) {
+		do.commandTimeout = d
+	}}
+}
+
+// DialLivenessTimeout specifies how long an async connection waits for data
+// from the server while replies are pending before the connection is
+// considered dead. It replaces the read timeout for async connections. A
+// connection that has no commands to send for this duration sends a PING to
+// check the link. Has no effect on connections created with Dial.
+func DialLivenessTimeout(d time.Duration) DialOption {
+	return DialOption{func(do *dialOptions) {
+		do.livenessTimeout = d
+	}}
+}
+
 // Dial connects to the Redis server at the given network and
 // address using the specified options.
 func Dial(network, address string, options ...DialOption) (Conn, error) {
@@ -192,6 +266,13 @@ func Dial(network, address string, options ...DialOption) (Conn, error) {
 		}
 	}
 
+	if do.protocol == 3 {
+		if _, err := c.Do("HELLO", 3); err != nil {
+			netConn.Close()
+			return nil, err
+		}
+	}
+
 	if do.db != 0 {
 		if _, err := c.Do("SELECT", do.db); err != nil {
 			netConn.Close()
@@ -491,34 +572,124 @@ func (c *conn) readReply() (interface{}, error) {
 		if n < 0 || err != nil {
 			return nil, err
 		}
-		p := make([]byte, n)
-		_, err = io.ReadFull(c.br, p)
+		return c.readBulk(n)
+	case '*', '~':
+		n, err := parseLen(line[1:])
+		if n < 0 || err != nil {
+			return nil, err
+		}
+		return c.readValues(n)
+	case '%':
+		n, err := parseLen(line[1:])
+		if n < 0 || err != nil {
+			return nil, err
+		}
+		return c.readValues(2 * n)
+	case '>':
+		n, err := parseLen(line[1:])
+		if n < 0 || err != nil {
+			return nil, err
+		}
+		r, err := c.readValues(n)
 		if err != nil {
 			return nil, err
 		}
-		if line, err := c.readLine(); err != nil {
+		return Push(r), nil
+	case '|':
+		// Attributes are read and discarded. The reply follows them.
+		n, err := parseLen(line[1:])
+		if n < 0 || err != nil {
+			return nil, err
+		}
+		if _, err := c.readValues(2 * n); err != nil {
 			return nil, err
-		} else if len(line) != 0 {
-			return nil, protocolError("bad bulk string format")
 		}
-		return p, nil
-	case '*':
+		return c.readReply()
+	case '_':
+		if len(line) != 1 {
+			return nil, protocolError("bad null format")
+		}
+		return nil, nil
+	case '#':
+		if len(line) != 2 || (line[1] != 't' && line[1] != 'f') {
+			return nil, protocolError("bad boolean format")
+		}
+		return line[1] == 't', nil
+	case ',':
+		f, err := strconv.ParseFloat(string(line[1:]), 64)
+		if err != nil {
+			return nil, protocolError("bad double format")
+		}
+		return f, nil
+	case '(':
+		n, ok := new(big.Int).SetString(string(line[1:]), 10)
+		if !ok {
+			return nil, protocolError("bad big number format")
+		}
+		return n, nil
+	case '=', '!':
 		n, err := parseLen(line[1:])
 		if n < 0 || err != nil {
 			return nil, err
 		}
-		r := make([]interface{}, n)
-		for i := range r {
-			r[i], err = c.readReply()
-			if err != nil {
-				return nil, err
-			}
+		p, err := c.readBulk(n)
+		if err != nil {
+			return nil, err
+		}
+		if line[0] == '!' {
+			return Error(p), nil
 		}
-		return r, nil
+		// Drop the three letter format and the colon that follows it.
+		if len(p) < 4 || p[3] != ':' {
+			return nil, protocolError("bad verbatim string format")
+		}
+		return p[4:], nil
 	}
 	return nil, protocolError("unexpected response line")
 }
 
+// readBulk reads a bulk string body of length n.
+func (c *conn) readBulk(n int) ([]byte, error) {
+	p := make([]byte, n)
+	_, err := io.ReadFull(c.br, p)
+	if err != nil {
+		return nil, err
+	}
+	if line, err := c.readLine(); err != nil {
+		return nil, err
+	} else if len(line) != 0 {
+		return nil, protocolError("bad bulk string format")
+	}
+	return p, nil
+}
+
+// readValues reads n replies.
+func (c *conn) readValues(n int) ([]interface{}, error) {
+	r := make([]interface{}, n)
+	for i := range r {
+		var err error
+		r[i], err = c.readReply()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// readResponse reads the reply to a command. RESP3 push messages sent by
+// the server before the reply are skipped.
+func (c *conn) readResponse() (interface{}, error) {
+	for {
+		reply, err := c.readReply()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := reply.(Push); !ok {
+			return reply, nil
+		}
+	}
+}
+
 func (c *conn) Send(cmd string, args ...interface{}) error {
 	c.mu.Lock()
 	c.pending += 1
@@ -598,7 +769,7 @@ func (c *conn) Do(cmd string, args ...interface{}) (interface{}, error) {
 	if cmd == "" {
 		reply := make([]interface{}, pending)
 		for i := range reply {
-			r, e := c.readReply()
+			r, e := c.readResponse()
 			if e != nil {
 				return nil, c.fatal(e)
 			}
@@ -611,7 +782,7 @@ func (c *conn) Do(cmd string, args ...interface{}) (interface{}, error) {
 	var reply interface{}
 	for i := 0; i <= pending; i++ {
 		var e error
-		if reply, e = c.readReply(); e != nil {
+		if reply, e = c.readResponse(); e != nil {
 			return nil, c.fatal(e)
 		}
 		if e, ok := reply.(Error); ok && err == nil {
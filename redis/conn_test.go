@@ -18,6 +18,7 @@ import (
 	"bytes"
 	"io"
 	"math"
+	"math/big"
 	"net"
 	"os"
 	"reflect"
@@ -198,6 +199,60 @@ var readTests = []struct {
 		"$6\r\nfoobarx\r\n",
 		errorSentinel,
 	},
+	{
+		"_\r\n",
+		nil,
+	},
+	{
+		"#t\r\n",
+		true,
+	},
+	{
+		",3.25\r\n",
+		3.25,
+	},
+	{
+		",inf\r\n",
+		math.Inf(1),
+	},
+	{
+		"(3492890328409238509324850943850943825024385\r\n",
+		bigInt("3492890328409238509324850943850943825024385"),
+	},
+	{
+		"=15\r\ntxt:Some string\r\n",
+		[]byte("Some string"),
+	},
+	{
+		"~2\r\n:1\r\n:2\r\n",
+		[]interface{}{int64(1), int64(2)},
+	},
+	{
+		"%1\r\n+key\r\n$5\r\nvalue\r\n",
+		[]interface{}{"key", []byte("value")},
+	},
+	{
+		">2\r\n$7\r\nmessage\r\n$2\r\nhi\r\n",
+		redis.Push{[]byte("message"), []byte("hi")},
+	},
+	{
+		"|1\r\n+ttl\r\n:3600\r\n:2\r\n",
+		int64(2),
+	},
+	{
+		"!10\r\nERR failed\r\n",
+		errorSentinel,
+	},
+	{
+		// "x" is not a valid boolean
+		"#x\r\n",
+		errorSentinel,
+	},
+}
+
+func bigInt(s string) *big.Int {
+	n, _ := new(big.Int).SetString(s, 10)
+	return n
 }
 
 func TestRead(t *testing.T) {
@@ -551,6 +606,19 @@ func TestDialURLDatabase(t *testing.T) {
 	}
 }
 
+func TestDialProtocol(t *testing.T) {
+	var buf bytes.Buffer
+	_, err := redis.Dial("", "", redis.DialProtocol(3), dialTestConn(strings.NewReader("%1\r\n$5\r\nproto\r\n:3\r\n"), &buf))
+	if err != nil {
+		t.Error("dial error:", err)
+	}
+	expected := "*2\r\n$5\r\nHELLO\r\n$1\r\n3\r\n"
+	actual := buf.String()
+	if actual != expected {
+		t.Errorf("commands = %q, want %q", actual, expected)
+	}
+}
+
 // Connect to local instance of Redis running on the default port.
 func ExampleDial() {
 	c, err := redis.Dial("tcp", ":6379")
@@ -28,6 +28,14 @@
 // In asynchronous mode, this library will only create a connection,
 // and you don't have to worry about performance issues,
 // nor do you have to spend a lot of time testing the number of connections.
+// On multi-core hosts, set AsyncPool.MaxActive to spread the commands over
+// several connections.
+//
+// Because an async connection is shared by all callers, commands that block
+// the connection or change its state, such as BLPOP, SUBSCRIBE, MULTI,
+// MONITOR and SELECT, are rejected with ErrUnsafeCommand. Use a connection
+// from a Pool for these commands. Transactions are sent with the AsyncTx
+// method of AsyncTxConn, which writes MULTI, the commands and EXEC together.
 //
 // Executing Commands
 //
@@ -61,6 +69,21 @@
 //  bulk string             []byte or nil if value not present.
 //  array                   []interface{} or nil if value not present.
 //
+// Connections dialed with DialProtocol(3) also receive the RESP3 types:
+//
+//  Redis type              Go type
+//  null                    nil
+//  double                  float64
+//  boolean                 bool
+//  big number              *big.Int
+//  verbatim string         []byte, the text without the format prefix
+//  blob error              redis.Error
+//  set                     []interface{}
+//  map                     []interface{} with alternating keys and values
+//  push                    redis.Push
+//
+// Attributes sent ahead of a reply are discarded.
+//
 // Use type assertions or the reply helper functions to convert from
 // interface{} to the specific Go type for the command result.
 //
@@ -132,7 +155,8 @@
 //      }
 //  }
 //
-// NOTE asynchronous mode does not support PUB/SUB
+// In asynchronous mode, use AsyncPubSub to receive notifications on Go
+// channels.
 //
 // Reply Helpers
 //
@@ -0,0 +1,148 @@
+// Copyright 2017 xiaofei, gistao
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package redis
+
+import (
+	"strconv"
+	"strings"
+)
+
+const (
+	keyspacePrefix = "__keyspace@"
+	keyeventPrefix = "__keyevent@"
+)
+
+// KeyEvent represents a keyspace notification.
+type KeyEvent struct {
+
+	// The database of the key.
+	DB int
+
+	// The event name, for example "set", "del" or "expired".
+	Event string
+
+	// The key.
+	Key string
+}
+
+// KeyspaceConn wraps a Conn with convenience methods for receiving keyspace
+// notifications.
+//
+//  c := redis.KeyspaceConn{Conn: conn}
+//  defer c.Close()
+//  c.Configure("Ex")
+//  c.SubscribeEvents(0, "expired")
+//  for {
+//    switch v := c.Receive().(type) {
+//    case redis.KeyEvent:
+//      fmt.Printf("%d: %s %s\n", v.DB, v.Event, v.Key)
+//    case error:
+//      return v
+//    }
+//  }
+type KeyspaceConn struct {
+	Conn Conn
+}
+
+// Close closes the connection.
+func (c KeyspaceConn) Close() error {
+	return c.Conn.Close()
+}
+
+// Configure enables notifications on the server by setting the
+// notify-keyspace-events parameter with CONFIG SET. The classes use the
+// characters documented for the parameter, for example "Ex" for key-event
+// notifications of expired keys. Call Configure before subscribing.
+func (c KeyspaceConn) Configure(classes string) error {
+	_, err := c.Conn.Do("CONFIG", "SET", "notify-keyspace-events", classes)
+	return err
+}
+
+// SubscribeEvents subscribes to key-event notifications for the events in
+// database db, or for all events if none is given. A negative db subscribes
+// to all databases.
+func (c KeyspaceConn) SubscribeEvents(db int, event ...string) error {
+	if len(event) == 0 {
+		event = []string{"*"}
+	}
+	return c.psubscribe(keyeventPrefix, db, event)
+}
+
+// SubscribeKeys subscribes to keyspace notifications for the keys matching
+// the patterns in database db, or for all keys if no pattern is given. A
+// negative db subscribes to all databases.
+func (c KeyspaceConn) SubscribeKeys(db int, pattern ...string) error {
+	if len(pattern) == 0 {
+		pattern = []string{"*"}
+	}
+	return c.psubscribe(keyspacePrefix, db, pattern)
+}
+
+func (c KeyspaceConn) psubscribe(prefix string, db int, names []string) error {
+	d := "*"
+	if db >= 0 {
+		d = strconv.Itoa(db)
+	}
+	args := make([]interface{}, len(names))
+	for i, name := range names {
+		args[i] = prefix + d + "__:" + name
+	}
+	return PubSubConn{Conn: c.Conn}.PSubscribe(args...)
+}
+
+// Receive returns a pushed message as a KeyEvent, Subscription, PMessage,
+// Pong or error. A PMessage is returned for notifications that are not
+// keyspace notifications.
+func (c KeyspaceConn) Receive() interface{} {
+	v := PubSubConn{Conn: c.Conn}.Receive()
+	if m, ok := v.(PMessage); ok {
+		if e, ok := parseKeyEvent(m.Channel, string(m.Data)); ok {
+			return e
+		}
+	}
+	return v
+}
+
+// parseKeyEvent parses a notification on a channel of the form
+// __keyevent@<db>__:<event> with the key as data, or
+// __keyspace@<db>__:<key> with the event as data.
+func parseKeyEvent(channel, data string) (KeyEvent, bool) {
+	var e KeyEvent
+	var keyspace bool
+	switch {
+	case strings.HasPrefix(channel, keyeventPrefix):
+		channel = channel[len(keyeventPrefix):]
+	case strings.HasPrefix(channel, keyspacePrefix):
+		channel = channel[len(keyspacePrefix):]
+		keyspace = true
+	default:
+		return e, false
+	}
+	i := strings.Index(channel, "__:")
+	if i < 0 {
+		return e, false
+	}
+	db, err := strconv.Atoi(channel[:i])
+	if err != nil {
+		return e, false
+	}
+	e.DB = db
+	if keyspace {
+		e.Key, e.Event = channel[i+3:], data
+	} else {
+		e.Event, e.Key = channel[i+3:], data
+	}
+	return e, true
+}
@@ -0,0 +1,88 @@
+// Copyright 2017 xiaofei, gistao
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package redis_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gistao/RedisGo-Async/redis"
+)
+
+func pmessage(pattern, channel, data string) string {
+	return "*4\r\n" + bulk("pmessage") + bulk(pattern) + bulk(channel) + bulk(data)
+}
+
+func TestKeyspaceConn(t *testing.T) {
+	var commands []string
+	s := &fakeServer{handler: func(args []string) string {
+		commands = append(commands, strings.Join(args, " "))
+		switch args[0] {
+		case "CONFIG":
+			return "+OK\r\n"
+		case "PSUBSCRIBE":
+			reply := ""
+			for i, pattern := range args[1:] {
+				reply += "*3\r\n" + bulk("psubscribe") + bulk(pattern) + ":" + string(rune('1'+i)) + "\r\n"
+			}
+			return reply +
+				pmessage(args[1], "__keyevent@0__:expired", "k1") +
+				pmessage(args[1], "__keyspace@12__:k2", "del") +
+				pmessage(args[1], "news", "hello")
+		}
+		return "-ERR unknown command\r\n"
+	}}
+	conn, err := redis.Dial("", "", dialFakeServer(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := redis.KeyspaceConn{Conn: conn}
+	defer c.Close()
+
+	if err := c.Configure("KEA"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SubscribeEvents(-1, "expired", "evicted"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []interface{}{
+		redis.Subscription{Kind: "psubscribe", Channel: "__keyevent@*__:expired", Count: 1},
+		redis.Subscription{Kind: "psubscribe", Channel: "__keyevent@*__:evicted", Count: 2},
+		redis.KeyEvent{DB: 0, Event: "expired", Key: "k1"},
+		redis.KeyEvent{DB: 12, Event: "del", Key: "k2"},
+		redis.PMessage{Pattern: "__keyevent@*__:expired", Channel: "news", Data: []byte("hello")},
+	}
+	for _, w := range want {
+		if v := c.Receive(); !reflect.DeepEqual(v, w) {
+			t.Errorf("Receive() = %#v, want %#v", v, w)
+		}
+	}
+
+	if err := c.SubscribeKeys(3); err != nil {
+		t.Fatal(err)
+	}
+	c.Receive()
+
+	wantCommands := []string{
+		"CONFIG SET notify-keyspace-events KEA",
+		"PSUBSCRIBE __keyevent@*__:expired __keyevent@*__:evicted",
+		"PSUBSCRIBE __keyspace@3__:*",
+	}
+	if !reflect.DeepEqual(commands, wantCommands) {
+		t.Errorf("commands = %q, want %q", commands, wantCommands)
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2017 xiaofei, gistao
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package redis
+
+import (
+	"context"
+	"strings"
+)
+
+// Library encapsulates the source of a Redis function library. See
+// https://redis.io/docs/manual/programmability/functions-intro/ for
+// information on functions in Redis.
+//
+// The FCall methods call a function of the library. If the server reports
+// that the function does not exist, then the library is loaded and the call
+// is retried.
+type Library struct {
+	name string
+	src  string
+}
+
+// NewLibrary returns a new library object. The source must start with the
+// shebang line that names the library, for example "#!lua name=mylib".
+func NewLibrary(src string) *Library {
+	var name string
+	line := src
+	if i := strings.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+	for _, f := range strings.Fields(line) {
+		if strings.HasPrefix(f, "name=") {
+			name = f[len("name="):]
+		}
+	}
+	return &Library{name: name, src: src}
+}
+
+// Name returns the library name.
+func (l *Library) Name() string {
+	return l.name
+}
+
+// Load loads the library with FUNCTION LOAD REPLACE. Loading a library that
+// is already loaded replaces it, so Load can be called on every connection.
+func (l *Library) Load(c Conn) error {
+	_, err := c.Do("FUNCTION", "LOAD", "REPLACE", l.src)
+	return err
+}
+
+// FCall calls the function with the FCALL command. The first keyCount values
+// of keysAndArgs are the keys.
+func (l *Library) FCall(c Conn, function string, keyCount int, keysAndArgs ...interface{}) (interface{}, error) {
+	return l.do(c, "FCALL", function, keyCount, keysAndArgs)
+}
+
+// FCallRO calls a read-only function with the FCALL_RO command.
+func (l *Library) FCallRO(c Conn, function string, keyCount int, keysAndArgs ...interface{}) (interface{}, error) {
+	return l.do(c, "FCALL_RO", function, keyCount, keysAndArgs)
+}
+
+// AsyncFCall calls the function with the FCALL command on an async
+// connection without waiting for the reply. If the function does not exist,
+// then the library is loaded and the call is retried when the result is
+// read.
+func (l *Library) AsyncFCall(c AsynConn, function string, keyCount int, keysAndArgs ...interface{}) (AsyncRet, error) {
+	return l.asyncDo(c, "FCALL", function, keyCount, keysAndArgs)
+}
+
+// AsyncFCallRO calls a read-only function with the FCALL_RO command on an
+// async connection.
+func (l *Library) AsyncFCallRO(c AsynConn, function string, keyCount int, keysAndArgs ...interface{}) (AsyncRet, error) {
+	return l.asyncDo(c, "FCALL_RO", function, keyCount, keysAndArgs)
+}
+
+func (l *Library) do(c Conn, cmd, function string, keyCount int, keysAndArgs []interface{}) (interface{}, error) {
+	args := fcallArgs(function, keyCount, keysAndArgs)
+	v, err := c.Do(cmd, args...)
+	if noFunction(err) {
+		if err := l.Load(c); err != nil {
+			return nil, err
+		}
+		v, err = c.Do(cmd, args...)
+	}
+	return v, err
+}
+
+func (l *Library) asyncDo(c AsynConn, cmd, function string, keyCount int, keysAndArgs []interface{}) (AsyncRet, error) {
+	args := fcallArgs(function, keyCount, keysAndArgs)
+	ret, err := c.AsyncDo(cmd, args...)
+	if err != nil {
+		return nil, err
+	}
+	return &libraryAsyncRet{l: l, c: c, ret: ret, cmd: cmd, args: args}, nil
+}
+
+func fcallArgs(function string, keyCount int, keysAndArgs []interface{}) []interface{} {
+	args := make([]interface{}, 2+len(keysAndArgs))
+	args[0] = function
+	args[1] = keyCount
+	copy(args[2:], keysAndArgs)
+	return args
+}
+
+type libraryAsyncRet struct {
+	l    *Library
+	c    AsynConn
+	ret  AsyncRet
+	cmd  string
+	args []interface{}
+}
+
+func (r *libraryAsyncRet) Get() (interface{}, error) {
+	return r.GetContext(context.Background())
+}
+
+func (r *libraryAsyncRet) GetContext(ctx context.Context) (interface{}, error) {
+	v, err := getContext(ctx, r.ret)
+	if noFunction(err) {
+		if _, err := doContext(ctx, r.c, "FUNCTION", []interface{}{"LOAD", "REPLACE", r.l.src}); err != nil {
+			return nil, err
+		}
+		v, err = doContext(ctx, r.c, r.cmd, r.args)
+	}
+	return v, err
+}
+
+// noFunction reports whether err is the error returned by FCALL for a
+// function that does not exist.
+func noFunction(err error) bool {
+	e, ok := err.(Error)
+	return ok && strings.Contains(strings.ToLower(string(e)), "function not found")
+}
@@ -0,0 +1,98 @@
+// Copyright 2017 xiaofei, gistao
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package redis_test
+
+import (
+	"testing"
+
+	"github.com/gistao/RedisGo-Async/redis"
+)
+
+const librarySource = "#!lua name=mylib\n" +
+	"redis.register_function('myincr', function(keys) return redis.call('INCR', keys[1]) end)"
+
+// fakeFunctionServer answers FCALL with "Function not found" until the
+// library is loaded.
+func fakeFunctionServer(loads *int) *fakeServer {
+	return &fakeServer{handler: func(args []string) string {
+		switch args[0] {
+		case "FUNCTION":
+			if args[1] != "LOAD" || args[2] != "REPLACE" || args[3] != librarySource {
+				return "-ERR bad FUNCTION command\r\n"
+			}
+			*loads++
+			return bulk("mylib")
+		case "FCALL", "FCALL_RO":
+			if *loads == 0 {
+				return "-ERR Function not found\r\n"
+			}
+			if args[1] != "myincr" || args[2] != "1" || args[3] != "key" {
+				return "-ERR bad FCALL command\r\n"
+			}
+			return ":1\r\n"
+		}
+		return "-ERR unknown command\r\n"
+	}}
+}
+
+func TestLibrary(t *testing.T) {
+	lib := redis.NewLibrary(librarySource)
+	if name := lib.Name(); name != "mylib" {
+		t.Errorf("Name() = %q, want mylib", name)
+	}
+
+	loads := 0
+	c, err := redis.Dial("", "", dialFakeServer(fakeFunctionServer(&loads)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if v, err := redis.Int(lib.FCall(c, "myincr", 1, "key")); err != nil || v != 1 {
+		t.Errorf("FCall returned %d, %v, want 1", v, err)
+	}
+	if v, err := redis.Int(lib.FCallRO(c, "myincr", 1, "key")); err != nil || v != 1 {
+		t.Errorf("FCallRO returned %d, %v, want 1", v, err)
+	}
+	if loads != 1 {
+		t.Errorf("library loaded %d times, want 1", loads)
+	}
+}
+
+func TestLibraryAsync(t *testing.T) {
+	lib := redis.NewLibrary(librarySource)
+
+	loads := 0
+	c := asyncDialFake(t, fakeFunctionServer(&loads))
+	defer c.Close()
+
+	ret, err := lib.AsyncFCall(c, "myincr", 1, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := redis.Int(ret.Get()); err != nil || v != 1 {
+		t.Errorf("AsyncFCall returned %d, %v, want 1", v, err)
+	}
+	ret, err = lib.AsyncFCallRO(c, "myincr", 1, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := redis.Int(ret.Get()); err != nil || v != 1 {
+		t.Errorf("AsyncFCallRO returned %d, %v, want 1", v, err)
+	}
+	if loads != 1 {
+		t.Errorf("library loaded %d times, want 1", loads)
+	}
+}
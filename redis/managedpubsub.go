@@ -0,0 +1,364 @@
+// Copyright 2017 xiaofei, gistao
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package redis
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// healthCheckData is the data of the PING commands sent by
+// ManagedPubSubConn. The replies to these commands are not returned from
+// Receive.
+const healthCheckData = "RedisGo-Async-health-check"
+
+// Reconnect represents the replacement of a failed subscriber connection.
+// The subscriptions are replayed on the new connection.
+type Reconnect struct {
+
+	// The error that ended the previous connection.
+	Err error
+}
+
+// ManagedPubSubConn is a subscriber that survives connection failures. The
+// subscriber remembers the subscribed channels, patterns and shard channels,
+// detects dead connections with PING and replays the subscriptions on a new
+// connection from Dial.
+//
+// Like PubSubConn, the application must call Receive in a loop. Receive
+// returns a Reconnect value after the connection is replaced.
+//
+//  psc := &redis.ManagedPubSubConn{
+//    Dial: pool.Dial,
+//    HealthCheckInterval: time.Minute,
+//  }
+//  defer psc.Close()
+//  psc.Subscribe("example")
+//  for {
+//    switch v := psc.Receive().(type) {
+//    case redis.Message:
+//      fmt.Printf("%s: message: %s\n", v.Channel, v.Data)
+//    case redis.Reconnect:
+//      fmt.Printf("reconnected after %v\n", v.Err)
+//    case error:
+//      return v
+//    }
+//  }
+type ManagedPubSubConn struct {
+	// Dial is an application supplied function for creating a connection.
+	Dial func() (Conn, error)
+
+	// HealthCheckInterval is the interval for sending PING when no
+	// notification is received. The connection is replaced when the PING is
+	// not answered within the interval. If the value is zero, PING is not
+	// sent.
+	HealthCheckInterval time.Duration
+
+	// MinBackoff and MaxBackoff bound the delay between dial attempts after a
+	// failure. The delay starts at MinBackoff, 100ms when zero, and doubles up
+	// to MaxBackoff.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// mu protects fields defined below.
+	mu        sync.Mutex
+	c         Conn
+	channels  map[string]bool
+	patterns  map[string]bool
+	shards    map[string]bool
+	lastRecv  time.Time
+	pingSent  bool
+	closed    bool
+	closeChan chan struct{}
+}
+
+// Subscribe subscribes to the channels.
+func (m *ManagedPubSubConn) Subscribe(channel ...interface{}) error {
+	return m.send("SUBSCRIBE", channel)
+}
+
+// PSubscribe subscribes to the patterns.
+func (m *ManagedPubSubConn) PSubscribe(pattern ...interface{}) error {
+	return m.send("PSUBSCRIBE", pattern)
+}
+
+// Unsubscribe unsubscribes from the channels, or from all channels if none
+// is given.
+func (m *ManagedPubSubConn) Unsubscribe(channel ...interface{}) error {
+	return m.send("UNSUBSCRIBE", channel)
+}
+
+// PUnsubscribe unsubscribes from the patterns, or from all patterns if none
+// is given.
+func (m *ManagedPubSubConn) PUnsubscribe(pattern ...interface{}) error {
+	return m.send("PUNSUBSCRIBE", pattern)
+}
+
+// SSubscribe subscribes to the shard channels.
+func (m *ManagedPubSubConn) SSubscribe(channel ...interface{}) error {
+	return m.send("SSUBSCRIBE", channel)
+}
+
+// SUnsubscribe unsubscribes from the shard channels, or from all shard
+// channels if none is given.
+func (m *ManagedPubSubConn) SUnsubscribe(channel ...interface{}) error {
+	return m.send("SUNSUBSCRIBE", channel)
+}
+
+// Ping sends a PING to the server with the specified data.
+func (m *ManagedPubSubConn) Ping(data string) error {
+	return m.send("PING", []interface{}{data})
+}
+
+// Receive returns a pushed message as a Subscription, Message, PMessage,
+// SMessage, Pong, Reconnect or error. Receive replaces a failed connection before
+// returning, so an error is returned only for error replies and after the
+// subscriber is closed.
+func (m *ManagedPubSubConn) Receive() interface{} {
+	for {
+		m.mu.Lock()
+		if err := m.start(); err != nil {
+			m.mu.Unlock()
+			return err
+		}
+		c := m.c
+		m.mu.Unlock()
+
+		v := PubSubConn{Conn: c}.Receive()
+
+		m.mu.Lock()
+		m.lastRecv = time.Now()
+		m.pingSent = false
+		m.mu.Unlock()
+
+		switch v := v.(type) {
+		case Pong:
+			if v.Data == healthCheckData {
+				continue
+			}
+		case error:
+			if c.Err() == nil {
+				return v
+			}
+			if err := m.reconnect(c); err != nil {
+				return err
+			}
+			return Reconnect{Err: v}
+		}
+		return v
+	}
+}
+
+// Close closes the connection and stops reconnecting.
+func (m *ManagedPubSubConn) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil
+	}
+	m.closed = true
+	if m.closeChan != nil {
+		close(m.closeChan)
+	}
+	if m.c == nil {
+		return nil
+	}
+	return m.c.Close()
+}
+
+func (m *ManagedPubSubConn) send(cmd string, args []interface{}) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.start(); err != nil {
+		return err
+	}
+
+	switch cmd {
+	case "SUBSCRIBE", "UNSUBSCRIBE":
+		m.channels = track(m.channels, cmd == "SUBSCRIBE", args)
+	case "PSUBSCRIBE", "PUNSUBSCRIBE":
+		m.patterns = track(m.patterns, cmd == "PSUBSCRIBE", args)
+	case "SSUBSCRIBE", "SUNSUBSCRIBE":
+		m.shards = track(m.shards, cmd == "SSUBSCRIBE", args)
+	}
+
+	m.c.Send(cmd, args...)
+	return m.c.Flush()
+}
+
+// track records subscribed names in set. Removing with no names clears the
+// set.
+func track(set map[string]bool, add bool, names []interface{}) map[string]bool {
+	if set == nil {
+		set = make(map[string]bool)
+	}
+	if !add && len(names) == 0 {
+		return make(map[string]bool)
+	}
+	for _, name := range names {
+		var s string
+		switch name := name.(type) {
+		case string:
+			s = name
+		case []byte:
+			s = string(name)
+		default:
+			s = fmt.Sprint(name)
+		}
+		if add {
+			set[s] = true
+		} else {
+			delete(set, s)
+		}
+	}
+	return set
+}
+
+// start dials the first connection and starts the health check. The caller
+// must hold mu.
+func (m *ManagedPubSubConn) start() error {
+	if m.closed {
+		return errConnClosed
+	}
+	if m.c != nil {
+		return nil
+	}
+	if m.Dial == nil {
+		return errors.New("RedisGo-Async: ManagedPubSubConn.Dial is nil")
+	}
+	c, err := m.Dial()
+	if err != nil {
+		return err
+	}
+	m.c = c
+	m.lastRecv = time.Now()
+	m.closeChan = make(chan struct{})
+	if m.HealthCheckInterval > 0 {
+		go m.healthCheck(m.HealthCheckInterval, m.closeChan)
+	}
+	return nil
+}
+
+// reconnect replaces the failed connection old and replays the
+// subscriptions.
+func (m *ManagedPubSubConn) reconnect(old Conn) error {
+	old.Close()
+
+	backoff := m.MinBackoff
+	if backoff <= 0 {
+		backoff = defaultMinBackoff
+	}
+	maxBackoff := m.MaxBackoff
+	if maxBackoff < backoff {
+		maxBackoff = backoff
+	}
+
+	m.mu.Lock()
+	closeChan := m.closeChan
+	m.mu.Unlock()
+
+	for {
+		select {
+		case <-closeChan:
+			return errConnClosed
+		default:
+		}
+
+		c, err := m.Dial()
+		if err == nil {
+			m.mu.Lock()
+			if m.closed {
+				m.mu.Unlock()
+				c.Close()
+				return errConnClosed
+			}
+			m.c = c
+			m.lastRecv = time.Now()
+			m.pingSent = false
+			err = m.resubscribe()
+			m.mu.Unlock()
+			if err == nil {
+				return nil
+			}
+			c.Close()
+		}
+
+		t := time.NewTimer(backoff)
+		select {
+		case <-t.C:
+		case <-closeChan:
+			t.Stop()
+			return errConnClosed
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// resubscribe replays the subscriptions on the current connection. The
+// caller must hold mu.
+func (m *ManagedPubSubConn) resubscribe() error {
+	for _, s := range []struct {
+		cmd string
+		set map[string]bool
+	}{
+		{"SUBSCRIBE", m.channels},
+		{"PSUBSCRIBE", m.patterns},
+		{"SSUBSCRIBE", m.shards},
+	} {
+		if len(s.set) == 0 {
+			continue
+		}
+		args := make([]interface{}, 0, len(s.set))
+		for name := range s.set {
+			args = append(args, name)
+		}
+		m.c.Send(s.cmd, args...)
+	}
+	return m.c.Flush()
+}
+
+// healthCheck sends PING when the connection is idle and closes the
+// connection when the PING is not answered. Closing the connection makes
+// Receive reconnect.
+func (m *ManagedPubSubConn) healthCheck(interval time.Duration, closeChan chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-closeChan:
+			return
+		}
+
+		m.mu.Lock()
+		switch {
+		case time.Since(m.lastRecv) < interval:
+		case m.pingSent:
+			m.c.Close()
+		case len(m.channels)+len(m.patterns)+len(m.shards) > 0:
+			// The server replies to PING with a pong notification only
+			// while the connection is subscribed.
+			m.c.Send("PING", healthCheckData)
+			m.c.Flush()
+			m.pingSent = true
+		}
+		m.mu.Unlock()
+	}
+}
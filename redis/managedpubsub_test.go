@@ -0,0 +1,170 @@
+// Copyright 2017 xiaofei, gistao
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package redis_test
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gistao/RedisGo-Async/redis"
+)
+
+// newFakeManagedPubSub returns a subscriber where the first connection
+// handles PING with the broken function and later connections answer PING.
+func newFakeManagedPubSub(broken func() string, healthCheck time.Duration) *redis.ManagedPubSubConn {
+	dialed := 0
+	return &redis.ManagedPubSubConn{
+		HealthCheckInterval: healthCheck,
+		MinBackoff:          time.Millisecond,
+		Dial: func() (redis.Conn, error) {
+			dialed++
+			first := dialed == 1
+			s := &fakeServer{handler: func(args []string) string {
+				switch args[0] {
+				case "PING":
+					if first {
+						return broken()
+					}
+					return "*2\r\n" + bulk("pong") + bulk(args[1])
+				case "SUBSCRIBE", "PSUBSCRIBE", "SSUBSCRIBE":
+					var reply string
+					for i, name := range args[1:] {
+						reply += "*3\r\n" + bulk(strings.ToLower(args[0])) + bulk(name) + ":" + string(rune('1'+i)) + "\r\n"
+					}
+					return reply
+				}
+				return "-ERR unknown command\r\n"
+			}}
+			return s.dial()()
+		},
+	}
+}
+
+func receiveKind(t *testing.T, m *redis.ManagedPubSubConn, want string) interface{} {
+	v := m.Receive()
+	var kind string
+	switch v := v.(type) {
+	case redis.Subscription:
+		kind = v.Kind + " " + v.Channel
+	case redis.Pong:
+		kind = "pong " + v.Data
+	case redis.Reconnect:
+		kind = "reconnect"
+	}
+	if kind != want {
+		t.Fatalf("Receive() returned %#v, want %s", v, want)
+	}
+	return v
+}
+
+func TestManagedPubSubReconnect(t *testing.T) {
+	m := newFakeManagedPubSub(func() string { return "" }, 0)
+	defer m.Close()
+
+	// The fake connections are not buffered, so each reply is received
+	// before the next command is sent.
+	if err := m.Subscribe("a"); err != nil {
+		t.Fatal(err)
+	}
+	receiveKind(t, m, "subscribe a")
+	if err := m.PSubscribe("p*"); err != nil {
+		t.Fatal(err)
+	}
+	receiveKind(t, m, "psubscribe p*")
+	if err := m.SSubscribe("s"); err != nil {
+		t.Fatal(err)
+	}
+	receiveKind(t, m, "ssubscribe s")
+
+	m.Ping("x")
+	if v := receiveKind(t, m, "reconnect").(redis.Reconnect); v.Err == nil {
+		t.Error("Reconnect.Err is nil")
+	}
+	receiveKind(t, m, "subscribe a")
+	receiveKind(t, m, "psubscribe p*")
+	receiveKind(t, m, "ssubscribe s")
+
+	m.Ping("x")
+	receiveKind(t, m, "pong x")
+
+	m.Close()
+	if _, ok := m.Receive().(error); !ok {
+		t.Error("Receive() after Close did not return an error")
+	}
+}
+
+func TestManagedPubSubHealthCheck(t *testing.T) {
+	stall := make(chan struct{})
+	defer close(stall)
+	m := newFakeManagedPubSub(func() string {
+		<-stall
+		return ""
+	}, 10*time.Millisecond)
+	defer m.Close()
+
+	if err := m.Subscribe("a"); err != nil {
+		t.Fatal(err)
+	}
+	receiveKind(t, m, "subscribe a")
+	receiveKind(t, m, "reconnect")
+	receiveKind(t, m, "subscribe a")
+
+	// Health check replies on the new connection are not returned.
+	received := make(chan interface{}, 1)
+	go func() { received <- m.Receive() }()
+	time.Sleep(50 * time.Millisecond)
+	m.Ping("user")
+	select {
+	case v := <-received:
+		if v != (redis.Pong{Data: "user"}) {
+			t.Fatalf("Receive() returned %#v, want pong user", v)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for pong")
+	}
+}
+
+func TestManagedPubSubCloseStopsDial(t *testing.T) {
+	var dials int32
+	m := newFakeManagedPubSub(func() string { return "" }, 0)
+	dial := m.Dial
+	m.Dial = func() (redis.Conn, error) {
+		atomic.AddInt32(&dials, 1)
+		return dial()
+	}
+
+	if err := m.Subscribe("a"); err != nil {
+		t.Fatal(err)
+	}
+	receiveKind(t, m, "subscribe a")
+
+	received := make(chan interface{}, 1)
+	go func() { received <- m.Receive() }()
+	time.Sleep(10 * time.Millisecond)
+	m.Close()
+	select {
+	case v := <-received:
+		if _, ok := v.(error); !ok {
+			t.Fatalf("Receive() after Close returned %#v, want error", v)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Receive blocked after Close")
+	}
+	if n := atomic.LoadInt32(&dials); n != 1 {
+		t.Errorf("dialed %d times, want 1", n)
+	}
+}
@@ -17,6 +17,7 @@ package redis
 import (
 	"bytes"
 	"container/list"
+	"context"
 	"crypto/rand"
 	"crypto/sha1"
 	"errors"
@@ -133,6 +134,11 @@ type Pool struct {
 	// Maximum number of idle connections in the pool.
 	MaxIdle int
 
+	// Minimum number of idle connections in the pool. When the number of
+	// idle connections falls below MinIdle, the pool dials connections in
+	// the background. MinIdle is limited by MaxIdle and MaxActive.
+	MinIdle int
+
 	// Maximum number of connections allocated by the pool at a given time.
 	// When zero, there is no limit on the number of connections in the pool.
 	MaxActive int
@@ -142,10 +148,35 @@ type Pool struct {
 	// the timeout to a value less than the server's timeout.
 	IdleTimeout time.Duration
 
+	// MaxIdleTime acts like IdleTimeout. When both are set, the smaller
+	// duration is used.
+	MaxIdleTime time.Duration
+
+	// Close connections older than this duration. If the value is zero, then
+	// connections are not closed based on age. A connection in use is closed
+	// when it is returned to the pool.
+	MaxConnLifetime time.Duration
+
+	// If ReaperInterval is not zero, then a background goroutine closes the
+	// idle connections that expired by MaxIdleTime, IdleTimeout or
+	// MaxConnLifetime at this interval. Otherwise, expired connections are
+	// closed by Get. The goroutine is started by the first Get and stopped
+	// by Close.
+	ReaperInterval time.Duration
+
 	// If Wait is true and the pool is at the MaxActive limit, then Get() waits
 	// for a connection to be returned to the pool before returning.
 	Wait bool
 
+	// Maximum number of attempts of a transaction run with Tx. When zero,
+	// the transaction is attempted ten times.
+	MaxTxAttempts int
+
+	// Delay before retrying a transaction run with Tx. The delay doubles
+	// after each failed attempt. When zero, the transaction is retried
+	// immediately.
+	TxBackoff time.Duration
+
 	// mu protects fields defined below.
 	mu     sync.Mutex
 	cond   *sync.Cond
@@ -154,11 +185,66 @@ type Pool struct {
 
 	// Stack of idleConn with most recently used at the front.
 	idle list.List
+
+	// Closed by Close to stop the reaper, which closes reaperDone on exit.
+	reaperStop chan struct{}
+	reaperDone chan struct{}
+
+	// True while a goroutine dials connections for MinIdle.
+	filling bool
+
+	stats PoolStats
+}
+
+// PoolStats contains pool statistics. The counters are totals since the pool
+// was created.
+type PoolStats struct {
+	// ActiveCount is the number of connections in the pool. The count
+	// includes idle connections and connections in use.
+	ActiveCount int
+
+	// IdleCount is the number of idle connections in the pool.
+	IdleCount int
+
+	// Dials is the number of connections dialed, including failed dials.
+	Dials int64
+
+	// DialErrors is the number of dials that returned an error.
+	DialErrors int64
+
+	// WaitCount is the number of Get calls that waited for a connection.
+	WaitCount int64
+
+	// WaitDuration is the total time spent waiting for a connection.
+	WaitDuration time.Duration
+
+	// Timeouts is the number of GetContext calls that returned because the
+	// context was done while waiting for a connection.
+	Timeouts int64
+
+	// Exhausted is the number of Get calls that returned ErrPoolExhausted.
+	Exhausted int64
+
+	// TestFailures is the number of connections that failed TestOnBorrow.
+	TestFailures int64
+
+	// IdleClosed is the number of connections closed by IdleTimeout or
+	// MaxIdleTime.
+	IdleClosed int64
+
+	// LifetimeClosed is the number of connections closed by
+	// MaxConnLifetime.
+	LifetimeClosed int64
+
+	// ErrorClosed is the number of connections closed because of a
+	// connection error or a failed TestOnBorrow.
+	ErrorClosed int64
 }
 
 type idleConn struct {
-	c Conn
-	t time.Time
+	c       Conn
+	t       time.Time
+	created time.Time
 }
 
 // NewPool creates a new pool.
@@ -174,11 +260,23 @@ func NewPool(newFn func() (Conn, error), maxIdle int) *Pool {
 // getting an underlying connection, then the connection Err, Do, Send, Flush
 // and Receive methods return that error.
 func (p *Pool) Get() Conn {
-	c, err := p.get()
+	c, created, err := p.get(context.Background())
 	if err != nil {
 		return errorConnection{err}
 	}
-	return &pooledConnection{p: p, c: c}
+	return &pooledConnection{p: p, c: c, created: created}
+}
+
+// GetContext acts like Get but returns ctx.Err() if the context is done
+// while waiting for a connection at the MaxActive limit. The context does not
+// affect the returned connection. If GetContext returns an error, then the
+// returned connection also returns that error.
+func (p *Pool) GetContext(ctx context.Context) (Conn, error) {
+	c, created, err := p.get(ctx)
+	if err != nil {
+		return errorConnection{err}, err
+	}
+	return &pooledConnection{p: p, c: c, created: created}, nil
 }
 
 // ActiveCount returns the number of connections in the pool. The count includes idle connections and connections in use.
@@ -197,6 +295,16 @@ func (p *Pool) IdleCount() int {
 	return idle
 }
 
+// Stats returns a snapshot of the pool statistics.
+func (p *Pool) Stats() PoolStats {
+	p.mu.Lock()
+	stats := p.stats
+	stats.ActiveCount = p.active
+	stats.IdleCount = p.idle.Len()
+	p.mu.Unlock()
+	return stats
+}
+
 // Close releases the resources used by the pool.
 func (p *Pool) Close() error {
 	p.mu.Lock()
@@ -207,7 +315,13 @@ func (p *Pool) Close() error {
 	if p.cond != nil {
 		p.cond.Broadcast()
 	}
+	stop, done := p.reaperStop, p.reaperDone
+	p.reaperStop = nil
 	p.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
 	for e := idle.Front(); e != nil; e = e.Next() {
 		e.Value.(idleConn).c.Close()
 	}
@@ -223,31 +337,104 @@ func (p *Pool) release() {
 	}
 }
 
-// get prunes stale connections and returns a connection from the idle list or
-// creates a new connection.
-func (p *Pool) get() (Conn, error) {
-	p.mu.Lock()
+// idleTimeout returns the smaller of IdleTimeout and MaxIdleTime.
+func (p *Pool) idleTimeout() time.Duration {
+	timeout := p.IdleTimeout
+	if p.MaxIdleTime > 0 && (timeout == 0 || p.MaxIdleTime < timeout) {
+		timeout = p.MaxIdleTime
+	}
+	return timeout
+}
 
-	// Prune stale connections.
+// expired reports whether a connection created at the given time exceeded
+// MaxConnLifetime.
+func (p *Pool) expired(created time.Time, now time.Time) bool {
+	return p.MaxConnLifetime > 0 && !created.Add(p.MaxConnLifetime).After(now)
+}
 
-	if timeout := p.IdleTimeout; timeout > 0 {
-		for i, n := 0, p.idle.Len(); i < n; i++ {
-			e := p.idle.Back()
-			if e == nil {
-				break
-			}
-			ic := e.Value.(idleConn)
-			if ic.t.Add(timeout).After(nowFunc()) {
-				break
+// prune removes stale connections from the idle list and returns them. The
+// caller must hold p.mu during the call and close the returned connections.
+func (p *Pool) prune() []Conn {
+	timeout := p.idleTimeout()
+	if timeout == 0 && p.MaxConnLifetime == 0 {
+		return nil
+	}
+	var stale []Conn
+	now := nowFunc()
+	for e := p.idle.Front(); e != nil; {
+		next := e.Next()
+		ic := e.Value.(idleConn)
+		idle := timeout > 0 && !ic.t.Add(timeout).After(now)
+		if idle || p.expired(ic.created, now) {
+			if idle {
+				p.stats.IdleClosed++
+			} else {
+				p.stats.LifetimeClosed++
 			}
 			p.idle.Remove(e)
 			p.release()
-			p.mu.Unlock()
-			ic.c.Close()
-			p.mu.Lock()
+			stale = append(stale, ic.c)
+		}
+		e = next
+	}
+	return stale
+}
+
+// startReaper starts the reaper if ReaperInterval is set. The caller must
+// hold p.mu during the call.
+func (p *Pool) startReaper() {
+	if p.ReaperInterval <= 0 || p.reaperStop != nil || p.closed {
+		return
+	}
+	p.reaperStop = make(chan struct{})
+	p.reaperDone = make(chan struct{})
+	go p.reap(p.ReaperInterval, p.reaperStop, p.reaperDone)
+}
+
+// reap closes stale idle connections at every interval until stop is closed.
+func (p *Pool) reap(interval time.Duration, stop, done chan struct{}) {
+	defer close(done)
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-stop:
+			return
+		}
+		p.mu.Lock()
+		stale := p.prune()
+		p.fill()
+		p.mu.Unlock()
+		for _, c := range stale {
+			c.Close()
+		}
+	}
+}
+
+// get prunes stale connections and returns a connection from the idle list or
+// creates a new connection. The creation time of the connection is returned
+// with the connection.
+func (p *Pool) get(ctx context.Context) (Conn, time.Time, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, time.Time{}, err
+	}
+
+	p.mu.Lock()
+	p.startReaper()
+
+	// Prune stale connections.
+
+	if stale := p.prune(); len(stale) > 0 {
+		p.mu.Unlock()
+		for _, c := range stale {
+			c.Close()
 		}
+		p.mu.Lock()
 	}
+	p.fill()
 
+	waited := false
 	for {
 
 		// Get idle connection.
@@ -259,13 +446,23 @@ func (p *Pool) get() (Conn, error) {
 			}
 			ic := e.Value.(idleConn)
 			p.idle.Remove(e)
+			p.fill()
 			test := p.TestOnBorrow
 			p.mu.Unlock()
-			if test == nil || test(ic.c, ic.t) == nil {
-				return ic.c, nil
+			err := ic.c.Err()
+			testFailed := false
+			if err == nil && test != nil {
+				testFailed = test(ic.c, ic.t) != nil
+			}
+			if err == nil && !testFailed {
+				return ic.c, ic.created, nil
 			}
 			ic.c.Close()
 			p.mu.Lock()
+			if testFailed {
+				p.stats.TestFailures++
+			}
+			p.stats.ErrorClosed++
 			p.release()
 		}
 
@@ -273,7 +470,7 @@ func (p *Pool) get() (Conn, error) {
 
 		if p.closed {
 			p.mu.Unlock()
-			return nil, errors.New("RedisGo-Async: get on closed pool")
+			return nil, time.Time{}, errors.New("RedisGo-Async: get on closed pool")
 		}
 
 		// Dial new connection if under limit.
@@ -283,32 +480,77 @@ func (p *Pool) get() (Conn, error) {
 			p.active += 1
 			p.mu.Unlock()
 			c, err := dial()
+			p.mu.Lock()
+			p.stats.Dials++
 			if err != nil {
-				p.mu.Lock()
+				p.stats.DialErrors++
 				p.release()
-				p.mu.Unlock()
 				c = nil
 			}
-			return c, err
+			p.mu.Unlock()
+			return c, nowFunc(), err
 		}
 
 		if !p.Wait {
+			p.stats.Exhausted++
 			p.mu.Unlock()
-			return nil, ErrPoolExhausted
+			return nil, time.Time{}, ErrPoolExhausted
 		}
 
 		if p.cond == nil {
 			p.cond = sync.NewCond(&p.mu)
 		}
-		p.cond.Wait()
+		if !waited {
+			waited = true
+			p.stats.WaitCount++
+		}
+		start := time.Now()
+		waitContext(ctx, p.cond)
+		p.stats.WaitDuration += time.Since(start)
+		if err := ctx.Err(); err != nil {
+			p.stats.Timeouts++
+			// Pass on the signal that may have woken this waiter.
+			p.cond.Signal()
+			p.mu.Unlock()
+			return nil, time.Time{}, err
+		}
+	}
+}
+
+// waitContext acts like cond.Wait but also returns when ctx is done. The
+// caller must check ctx.Err() after the call.
+func waitContext(ctx context.Context, cond *sync.Cond) {
+	if ctx.Done() == nil {
+		cond.Wait()
+		return
 	}
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			// The waiter holds the lock until it waits, so the broadcast
+			// cannot be missed.
+			cond.L.Lock()
+			cond.Broadcast()
+			cond.L.Unlock()
+		case <-done:
+		}
+	}()
+	cond.Wait()
+	close(done)
 }
 
-func (p *Pool) put(c Conn, forceClose bool) error {
+func (p *Pool) put(c Conn, created time.Time, forceClose bool) error {
 	err := c.Err()
+	now := nowFunc()
 	p.mu.Lock()
-	if !p.closed && err == nil && !forceClose {
-		p.idle.PushFront(idleConn{t: nowFunc(), c: c})
+	switch {
+	case err != nil:
+		p.stats.ErrorClosed++
+	case p.expired(created, now):
+		p.stats.LifetimeClosed++
+	case !p.closed && !forceClose:
+		p.idle.PushFront(idleConn{t: now, c: c, created: created})
 		if p.idle.Len() > p.MaxIdle {
 			c = p.idle.Remove(p.idle.Back()).(idleConn).c
 		} else {
@@ -325,14 +567,128 @@ func (p *Pool) put(c Conn, forceClose bool) error {
 	}
 
 	p.release()
+	p.fill()
 	p.mu.Unlock()
 	return c.Close()
 }
 
+// fill starts a goroutine that dials connections until the pool has MinIdle
+// idle connections. The caller must hold p.mu during the call.
+func (p *Pool) fill() {
+	if p.MinIdle <= 0 || p.filling || p.closed || p.idle.Len() >= p.MinIdle {
+		return
+	}
+	p.filling = true
+	go p.fillIdle()
+}
+
+func (p *Pool) fillIdle() {
+	for {
+		p.mu.Lock()
+		n := p.idle.Len()
+		if p.closed || n >= p.MinIdle || n >= p.MaxIdle || (p.MaxActive > 0 && p.active >= p.MaxActive) {
+			p.filling = false
+			p.mu.Unlock()
+			return
+		}
+		p.active += 1
+		dial := p.Dial
+		p.mu.Unlock()
+
+		c, err := dial()
+		if err != nil {
+			// Stop on error. The next Get or put tries again.
+			p.mu.Lock()
+			p.stats.Dials++
+			p.stats.DialErrors++
+			p.release()
+			p.filling = false
+			p.mu.Unlock()
+			return
+		}
+		p.addIdle(c)
+	}
+}
+
+// addIdle adds a new connection counted in p.active to the idle list.
+func (p *Pool) addIdle(c Conn) {
+	now := nowFunc()
+	p.mu.Lock()
+	p.stats.Dials++
+	if p.closed || p.idle.Len() >= p.MaxIdle {
+		p.release()
+		p.mu.Unlock()
+		c.Close()
+		return
+	}
+	p.idle.PushFront(idleConn{t: now, c: c, created: now})
+	if p.cond != nil {
+		p.cond.Signal()
+	}
+	p.mu.Unlock()
+}
+
+// Prewarm dials n connections in parallel and adds them to the idle list,
+// so that the first commands do not wait for a dial. The number of
+// connections is limited by MaxIdle and MaxActive. Prewarm returns the first
+// dial error, or ctx.Err() if the context is done first. In the latter case,
+// the connections dialed later are still added to the pool.
+func (p *Pool) Prewarm(ctx context.Context, n int) error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return errPoolClosed
+	}
+	if max := p.MaxIdle - p.idle.Len(); n > max {
+		n = max
+	}
+	if max := p.MaxActive - p.active; p.MaxActive > 0 && n > max {
+		n = max
+	}
+	if n <= 0 {
+		p.mu.Unlock()
+		return nil
+	}
+	p.active += n
+	dial := p.Dial
+	p.mu.Unlock()
+
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			c, err := dial()
+			if err != nil {
+				p.mu.Lock()
+				p.stats.Dials++
+				p.stats.DialErrors++
+				p.release()
+				p.mu.Unlock()
+			} else {
+				p.addIdle(c)
+			}
+			errs <- err
+		}()
+	}
+
+	var err error
+	for i := 0; i < n; i++ {
+		select {
+		case e := <-errs:
+			if e != nil && err == nil {
+				err = e
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return err
+}
+
 type pooledConnection struct {
-	p     *Pool
-	c     Conn
-	state int
+	p       *Pool
+	c       Conn
+	state   int
+	created time.Time
 }
 
 var (
@@ -366,9 +722,14 @@ func (pc *pooledConnection) Close() error {
 		c.Send("UNWATCH")
 		pc.state &^= internal.WatchState
 	}
-	if pc.state&internal.SubscribeState != 0 {
-		c.Send("UNSUBSCRIBE")
-		c.Send("PUNSUBSCRIBE")
+	if pc.state&(internal.SubscribeState|internal.ShardSubscribeState) != 0 {
+		if pc.state&internal.SubscribeState != 0 {
+			c.Send("UNSUBSCRIBE")
+			c.Send("PUNSUBSCRIBE")
+		}
+		if pc.state&internal.ShardSubscribeState != 0 {
+			c.Send("SUNSUBSCRIBE")
+		}
 		// To detect the end of the message stream, ask the server to echo
 		// a sentinel value and read until we see that value.
 		sentinelOnce.Do(initSentinel)
@@ -380,13 +741,13 @@ func (pc *pooledConnection) Close() error {
 				break
 			}
 			if p, ok := p.([]byte); ok && bytes.Equal(p, sentinel) {
-				pc.state &^= internal.SubscribeState
+				pc.state &^= internal.SubscribeState | internal.ShardSubscribeState
 				break
 			}
 		}
 	}
 	c.Do("")
-	pc.p.put(c, pc.state != 0)
+	pc.p.put(c, pc.created, pc.state != 0)
 	return nil
 }
 
@@ -15,6 +15,7 @@
 package redis_test
 
 import (
+	"context"
 	"errors"
 	"io"
 	"reflect"
@@ -63,6 +64,10 @@ type poolDialer struct {
 	open     int
 	commands []string
 	dialErr  error
+
+	// If server is set, then connections are dialed to the fake server
+	// instead of the default server.
+	server *fakeServer
 }
 
 func (d *poolDialer) dial() (redis.Conn, error) {
@@ -73,7 +78,13 @@ func (d *poolDialer) dial() (redis.Conn, error) {
 	if dialErr != nil {
 		return nil, d.dialErr
 	}
-	c, err := redis.DialDefaultServer()
+	var c redis.Conn
+	var err error
+	if d.server != nil {
+		c, err = redis.Dial("", "", dialFakeServer(d.server))
+	} else {
+		c, err = redis.DialDefaultServer()
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -237,6 +248,193 @@ func TestPoolTimeout(t *testing.T) {
 	d.check("2", p, 2, 1, 0)
 }
 
+var pongServer = &fakeServer{handler: func(args []string) string { return "+PONG\r\n" }}
+
+func TestPoolMaxIdleTime(t *testing.T) {
+	d := poolDialer{t: t, server: pongServer}
+	p := &redis.Pool{
+		MaxIdle:     2,
+		MaxIdleTime: 300 * time.Second,
+		Dial:        d.dial,
+	}
+	defer p.Close()
+
+	now := time.Now()
+	redis.SetNowFunc(func() time.Time { return now })
+	defer redis.SetNowFunc(time.Now)
+
+	c := p.Get()
+	c.Do("PING")
+	c.Close()
+
+	d.check("1", p, 1, 1, 0)
+
+	now = now.Add(p.MaxIdleTime)
+
+	c = p.Get()
+	c.Do("PING")
+	c.Close()
+
+	d.check("2", p, 2, 1, 0)
+}
+
+func TestPoolMaxConnLifetime(t *testing.T) {
+	d := poolDialer{t: t, server: pongServer}
+	p := &redis.Pool{
+		MaxIdle:         2,
+		MaxConnLifetime: 300 * time.Second,
+		Dial:            d.dial,
+	}
+	defer p.Close()
+
+	now := time.Now()
+	redis.SetNowFunc(func() time.Time { return now })
+	defer redis.SetNowFunc(time.Now)
+
+	c := p.Get()
+	c.Do("PING")
+	c.Close()
+
+	d.check("1", p, 1, 1, 0)
+
+	// Get closes the expired idle connection.
+	now = now.Add(p.MaxConnLifetime)
+	c = p.Get()
+	c.Do("PING")
+
+	d.check("2", p, 2, 1, 1)
+
+	// An expired connection is closed when returned to the pool.
+	now = now.Add(p.MaxConnLifetime)
+	c.Close()
+
+	d.check("3", p, 2, 0, 0)
+}
+
+func TestPoolReaper(t *testing.T) {
+	d := poolDialer{t: t, server: pongServer}
+	p := &redis.Pool{
+		MaxIdle:        2,
+		MaxIdleTime:    10 * time.Millisecond,
+		ReaperInterval: time.Millisecond,
+		Dial:           d.dial,
+	}
+
+	c := p.Get()
+	c.Do("PING")
+	c.Close()
+
+	waitFor(t, "reaper", func() bool { return p.IdleCount() == 0 })
+	d.check("reaped", p, 1, 0, 0)
+
+	p.Close()
+	if _, err := p.Get().Do("PING"); err == nil {
+		t.Error("expected error after pool closed")
+	}
+}
+
+func TestPoolMinIdle(t *testing.T) {
+	d := poolDialer{t: t, server: pongServer}
+	p := &redis.Pool{
+		MaxIdle: 3,
+		MinIdle: 2,
+		Dial:    d.dial,
+	}
+	defer p.Close()
+
+	c := p.Get()
+	waitFor(t, "fill", func() bool { return p.IdleCount() == 2 })
+	d.check("1", p, 3, 3, 1)
+	c.Close()
+	d.check("2", p, 3, 3, 0)
+
+	// A failed connection is replaced.
+	c1 := p.Get()
+	c2 := p.Get()
+	c1.Do("ERR", io.EOF)
+	c1.Close()
+	waitFor(t, "refill", func() bool { return p.IdleCount() == 2 })
+	d.check("3", p, 4, 3, 1)
+	c2.Close()
+}
+
+func TestPoolPrewarm(t *testing.T) {
+	d := poolDialer{t: t, server: pongServer}
+	p := &redis.Pool{
+		MaxIdle:   5,
+		MaxActive: 4,
+		Dial:      d.dial,
+	}
+	defer p.Close()
+
+	if err := p.Prewarm(context.Background(), 10); err != nil {
+		t.Fatal(err)
+	}
+	d.check("1", p, 4, 4, 0)
+
+	d.dialErr = errors.New("dial error")
+	p.Get().Close()
+	if err := p.Prewarm(context.Background(), 1); err != nil {
+		t.Errorf("Prewarm at MaxActive returned %v", err)
+	}
+	p.MaxActive = 0
+	if err := p.Prewarm(context.Background(), 1); err != d.dialErr {
+		t.Errorf("Prewarm returned %v, want %v", err, d.dialErr)
+	}
+	d.check("2", p, 5, 4, 0)
+}
+
+func TestPoolStats(t *testing.T) {
+	d := poolDialer{t: t, server: pongServer}
+	var testErr error
+	p := &redis.Pool{
+		MaxIdle:      1,
+		MaxActive:    1,
+		Dial:         d.dial,
+		TestOnBorrow: func(redis.Conn, time.Time) error { return testErr },
+	}
+	defer p.Close()
+
+	c := p.Get()
+	c.Do("PING")
+	if _, err := p.Get().Do("PING"); err != redis.ErrPoolExhausted {
+		t.Errorf("Get() at MaxActive returned %v, want %v", err, redis.ErrPoolExhausted)
+	}
+	c.Close()
+
+	testErr = errors.New("test error")
+	c = p.Get()
+	c.Do("PING")
+	testErr = nil
+
+	p.Wait = true
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := p.GetContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("GetContext() returned %v, want %v", err, context.DeadlineExceeded)
+	}
+	c.Close()
+
+	stats := p.Stats()
+	if stats.WaitDuration <= 0 {
+		t.Errorf("WaitDuration = %v, want > 0", stats.WaitDuration)
+	}
+	stats.WaitDuration = 0
+	want := redis.PoolStats{
+		ActiveCount:  1,
+		IdleCount:    1,
+		Dials:        2,
+		WaitCount:    1,
+		Timeouts:     1,
+		Exhausted:    1,
+		TestFailures: 1,
+		ErrorClosed:  1,
+	}
+	if stats != want {
+		t.Errorf("Stats() = %+v, want %+v", stats, want)
+	}
+}
+
 func TestPoolConcurrenSendReceive(t *testing.T) {
 	p := &redis.Pool{
 		Dial: redis.DialDefaultServer,
@@ -279,6 +477,41 @@ func TestPoolBorrowCheck(t *testing.T) {
 	d.check("1", p, 10, 1, 0)
 }
 
+func TestPoolIdleConnErr(t *testing.T) {
+	d := poolDialer{t: t}
+	s := &fakeServer{handler: func(args []string) string { return "+OK\r\n" }}
+	var conns []*poolTestConn
+	p := &redis.Pool{
+		MaxIdle: 1,
+		Dial: func() (redis.Conn, error) {
+			c, err := redis.Dial("", "", dialFakeServer(s))
+			if err != nil {
+				return nil, err
+			}
+			pc := &poolTestConn{d: &d, Conn: c}
+			conns = append(conns, pc)
+			return pc, nil
+		},
+	}
+	defer p.Close()
+
+	c := p.Get()
+	c.Do("PING")
+	c.Close()
+
+	// A connection that fails while idle, such as a connection to a replaced
+	// master, is closed instead of returned by Get.
+	conns[0].err = io.EOF
+	c = p.Get()
+	if _, err := c.Do("PING"); err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	if len(conns) != 2 {
+		t.Errorf("dialed %d connections, want 2", len(conns))
+	}
+}
+
 func TestPoolMaxActive(t *testing.T) {
 	d := poolDialer{t: t}
 	p := &redis.Pool{
@@ -476,6 +709,44 @@ func TestWaitPool(t *testing.T) {
 	d.check("done", p, 1, 1, 0)
 }
 
+func TestWaitPoolGetContext(t *testing.T) {
+	d := poolDialer{t: t, server: pongServer}
+	p := &redis.Pool{
+		MaxIdle:   1,
+		MaxActive: 1,
+		Dial:      d.dial,
+		Wait:      true,
+	}
+	defer p.Close()
+
+	c := p.Get()
+	errs := make(chan error, 1)
+	go func() {
+		c := p.Get()
+		_, err := c.Do("PING")
+		c.Close()
+		errs <- err
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := p.GetContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("GetContext() returned %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// The other waiter gets the connection when it is returned.
+	c.Close()
+	select {
+	case err := <-errs:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for blocked goroutine")
+	}
+	d.check("done", p, 1, 1, 0)
+}
+
 func TestWaitPoolClose(t *testing.T) {
 	d := poolDialer{t: t}
 	p := &redis.Pool{
@@ -19,7 +19,8 @@ import "errors"
 // Subscription represents a subscribe or unsubscribe notification.
 type Subscription struct {
 
-	// Kind is "subscribe", "unsubscribe", "psubscribe" or "punsubscribe"
+	// Kind is "subscribe", "unsubscribe", "psubscribe", "punsubscribe",
+	// "ssubscribe" or "sunsubscribe"
 	Kind string
 
 	// The channel that was changed.
@@ -52,6 +53,16 @@ type PMessage struct {
 	Data []byte
 }
 
+// SMessage represents a sharded message notification.
+type SMessage struct {
+
+	// The originating shard channel.
+	Channel string
+
+	// The message data.
+	Data []byte
+}
+
 // Pong represents a pubsub pong notification.
 type Pong struct {
 	Data string
@@ -93,15 +104,30 @@ func (c PubSubConn) PUnsubscribe(channel ...interface{}) error {
 	return c.Conn.Flush()
 }
 
+// SSubscribe subscribes the connection to the specified shard channels. In a
+// cluster, all of the channels must hash to the same slot and the connection
+// must be to the node serving the slot. See ClusterPool.GetForKey.
+func (c PubSubConn) SSubscribe(channel ...interface{}) error {
+	c.Conn.Send("SSUBSCRIBE", channel...)
+	return c.Conn.Flush()
+}
+
+// SUnsubscribe unsubscribes the connection from the given shard channels, or
+// from all of them if none is given.
+func (c PubSubConn) SUnsubscribe(channel ...interface{}) error {
+	c.Conn.Send("SUNSUBSCRIBE", channel...)
+	return c.Conn.Flush()
+}
+
 // Ping sends a PING to the server with the specified data.
 func (c PubSubConn) Ping(data string) error {
 	c.Conn.Send("PING", data)
 	return c.Conn.Flush()
 }
 
-// Receive returns a pushed message as a Subscription, Message, PMessage, Pong
-// or error. The return value is intended to be used directly in a type switch
-// as illustrated in the PubSubConn example.
+// Receive returns a pushed message as a Subscription, Message, PMessage,
+// SMessage, Pong or error. The return value is intended to be used directly
+// in a type switch as illustrated in the PubSubConn example.
 func (c PubSubConn) Receive() interface{} {
 	reply, err := Values(c.Conn.Receive())
 	if err != nil {
@@ -127,7 +153,13 @@ func (c PubSubConn) Receive() interface{} {
 			return err
 		}
 		return pm
-	case "subscribe", "psubscribe", "unsubscribe", "punsubscribe":
+	case "smessage":
+		var m SMessage
+		if _, err := Scan(reply, &m.Channel, &m.Data); err != nil {
+			return err
+		}
+		return m
+	case "subscribe", "psubscribe", "unsubscribe", "punsubscribe", "ssubscribe", "sunsubscribe":
 		s := Subscription{Kind: kind}
 		if _, err := Scan(reply, &s.Channel, &s.Count); err != nil {
 			return err
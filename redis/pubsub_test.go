@@ -146,3 +146,38 @@ func TestPushed(t *testing.T) {
 	c.Conn.Flush()
 	expectPushed(t, c, `Send("PING")`, redis.Pong{})
 }
+
+func TestShardedPushed(t *testing.T) {
+	var commands []string
+	s := &fakeServer{handler: func(args []string) string {
+		commands = append(commands, args[0])
+		switch args[0] {
+		case "SSUBSCRIBE":
+			return "*3\r\n" + bulk("ssubscribe") + bulk(args[1]) + ":1\r\n" +
+				"*3\r\n" + bulk("smessage") + bulk(args[1]) + bulk("hello")
+		case "SUNSUBSCRIBE":
+			return "*3\r\n" + bulk("sunsubscribe") + bulk("s1") + ":0\r\n"
+		case "ECHO":
+			return bulk(args[1])
+		}
+		return "+OK\r\n"
+	}}
+	p := &redis.Pool{
+		MaxIdle: 1,
+		Dial:    s.dial(),
+	}
+	defer p.Close()
+
+	c := redis.PubSubConn{Conn: p.Get()}
+	c.SSubscribe("s1")
+	expectPushed(t, c, "SSubscribe(s1)", redis.Subscription{Kind: "ssubscribe", Channel: "s1", Count: 1})
+	expectPushed(t, c, "SPUBLISH s1 hello", redis.SMessage{Channel: "s1", Data: []byte("hello")})
+	c.Close()
+
+	if want := []string{"SSUBSCRIBE", "SUNSUBSCRIBE", "ECHO"}; !reflect.DeepEqual(commands[:len(want)], want) {
+		t.Errorf("commands = %v, want %v", commands, want)
+	}
+	if n := p.IdleCount(); n != 1 {
+		t.Errorf("IdleCount() = %d, want 1", n)
+	}
+}
@@ -0,0 +1,264 @@
+// Copyright 2017 xiaofei, gistao
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package redis
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const defaultMinBackoff = 100 * time.Millisecond
+
+// reconnectConn is an async connection that replaces the underlying
+// connection after a fatal error. See DialReconnect.
+type reconnectConn struct {
+	dial       func() (*asynConn, error)
+	minBackoff time.Duration
+	maxBackoff time.Duration
+
+	// mu protects fields defined below.
+	mu           sync.Mutex
+	c            *asynConn
+	err          error
+	reconnecting bool
+	closed       bool
+	closeChan    chan struct{}
+}
+
+func newReconnectConn(c *asynConn, dial func() (*asynConn, error), minBackoff, maxBackoff time.Duration) *reconnectConn {
+	if minBackoff <= 0 {
+		minBackoff = defaultMinBackoff
+	}
+	if maxBackoff < minBackoff {
+		maxBackoff = minBackoff
+	}
+	rc := &reconnectConn{
+		dial:       dial,
+		minBackoff: minBackoff,
+		maxBackoff: maxBackoff,
+		c:          c,
+		closeChan:  make(chan struct{}),
+	}
+	go rc.watch(c)
+	return rc
+}
+
+// get returns the current connection. While the connection is redialed, get
+// returns ErrConnLost wrapping the last dial error or the error that caused
+// the failure. Commands are not queued while the connection is down.
+func (rc *reconnectConn) get() (*asynConn, error) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if rc.closed {
+		return nil, errConnClosed
+	}
+	if rc.reconnecting {
+		return nil, &connLostError{cause: rc.err}
+	}
+	if err := rc.c.Err(); err != nil {
+		// The connection failed, but watch did not run yet.
+		return nil, &connLostError{cause: err}
+	}
+	return rc.c, nil
+}
+
+// watch starts redialing in the background as soon as c fails.
+func (rc *reconnectConn) watch(c *asynConn) {
+	select {
+	case <-c.failed:
+	case <-rc.closeChan:
+		return
+	}
+
+	rc.mu.Lock()
+	if rc.closed || rc.c != c {
+		rc.mu.Unlock()
+		return
+	}
+	rc.err = c.Err()
+	rc.reconnecting = true
+	rc.mu.Unlock()
+
+	rc.reconnect()
+}
+
+func (rc *reconnectConn) reconnect() {
+	backoff := rc.minBackoff
+	for {
+		c, err := rc.dial()
+
+		rc.mu.Lock()
+		if rc.closed {
+			rc.mu.Unlock()
+			if c != nil {
+				c.Close()
+			}
+			return
+		}
+		if err == nil {
+			old := rc.c
+			rc.c = c
+			rc.err = nil
+			rc.reconnecting = false
+			rc.mu.Unlock()
+			old.Close()
+			go rc.watch(c)
+			return
+		}
+		rc.err = err
+		rc.mu.Unlock()
+
+		t := time.NewTimer(backoff)
+		select {
+		case <-t.C:
+		case <-rc.closeChan:
+			t.Stop()
+			return
+		}
+		if backoff *= 2; backoff > rc.maxBackoff {
+			backoff = rc.maxBackoff
+		}
+	}
+}
+
+func (rc *reconnectConn) current() *asynConn {
+	rc.mu.Lock()
+	c := rc.c
+	rc.mu.Unlock()
+	return c
+}
+
+func (rc *reconnectConn) lastActive() time.Time {
+	return rc.current().lastActive()
+}
+
+func (rc *reconnectConn) inflightCount() int {
+	return rc.current().inflightCount()
+}
+
+func (rc *reconnectConn) QueueLen() int {
+	return rc.current().QueueLen()
+}
+
+func (rc *reconnectConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	c, err := rc.get()
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(cmd, args...)
+}
+
+func (rc *reconnectConn) doSetup(cmd string, args ...interface{}) (interface{}, error) {
+	c, err := rc.get()
+	if err != nil {
+		return nil, err
+	}
+	return c.doSetup(cmd, args...)
+}
+
+func (rc *reconnectConn) AsyncDo(cmd string, args ...interface{}) (AsyncRet, error) {
+	c, err := rc.get()
+	if err != nil {
+		return nil, err
+	}
+	return c.AsyncDo(cmd, args...)
+}
+
+func (rc *reconnectConn) DoContext(ctx context.Context, cmd string, args ...interface{}) (interface{}, error) {
+	c, err := rc.get()
+	if err != nil {
+		return nil, err
+	}
+	return c.DoContext(ctx, cmd, args...)
+}
+
+func (rc *reconnectConn) AsyncDoContext(ctx context.Context, cmd string, args ...interface{}) (AsyncRet, error) {
+	c, err := rc.get()
+	if err != nil {
+		return nil, err
+	}
+	return c.AsyncDoContext(ctx, cmd, args...)
+}
+
+func (rc *reconnectConn) AsyncTx(cmds ...Command) (AsyncRet, error) {
+	c, err := rc.get()
+	if err != nil {
+		return nil, err
+	}
+	return c.AsyncTx(cmds...)
+}
+
+func (rc *reconnectConn) AsyncTxContext(ctx context.Context, cmds ...Command) (AsyncRet, error) {
+	c, err := rc.get()
+	if err != nil {
+		return nil, err
+	}
+	return c.AsyncTxContext(ctx, cmds...)
+}
+
+// Err returns a non-nil value only after the connection is closed. A failed
+// underlying connection is redialed, so it is not reported here.
+func (rc *reconnectConn) Err() error {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.closed {
+		return errConnClosed
+	}
+	return nil
+}
+
+func (rc *reconnectConn) Close() error {
+	c := rc.shutdown()
+	if c == nil {
+		return nil
+	}
+	return c.Close()
+}
+
+func (rc *reconnectConn) CloseGraceful(ctx context.Context) error {
+	c := rc.shutdown()
+	if c == nil {
+		return nil
+	}
+	return c.CloseGraceful(ctx)
+}
+
+// shutdown stops redialing and returns the current connection, or nil if the
+// connection was already closed.
+func (rc *reconnectConn) shutdown() *asynConn {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if rc.closed {
+		return nil
+	}
+	rc.closed = true
+	close(rc.closeChan)
+	return rc.c
+}
+
+func (rc *reconnectConn) Send(cmd string, args ...interface{}) error {
+	return errorCompatibility
+}
+
+func (rc *reconnectConn) Flush() error {
+	return errorCompatibility
+}
+
+func (rc *reconnectConn) Receive() (interface{}, error) {
+	return nil, errorCompatibility
+}
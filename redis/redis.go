@@ -14,11 +14,17 @@
 
 package redis
 
+import "context"
+
 // Error represents an error returned in a command reply.
 type Error string
 
 func (err Error) Error() string { return string(err) }
 
+// Push represents a RESP3 push message sent by the server out of band, for
+// example a pub/sub message on a connection using protocol version 3.
+type Push []interface{}
+
 // Conn represents a connection to a Redis server.
 type Conn interface {
 	// Close closes the connection.
@@ -46,6 +52,17 @@ type AsyncRet interface {
 	Get() (reply interface{}, err error)
 }
 
+// AsyncRetWithContext is an AsyncRet that supports waiting for the result
+// with a context. The results returned by this package implement it.
+type AsyncRetWithContext interface {
+	AsyncRet
+
+	// GetContext acts like Get but returns ctx.Err() if the context is done
+	// before the result is available. The reply is still consumed by the
+	// connection so that later commands are not affected.
+	GetContext(ctx context.Context) (reply interface{}, err error)
+}
+
 // AsynConn represents a aync connection to a Redis server.
 type AsynConn interface {
 	Conn
@@ -53,6 +70,58 @@ type AsynConn interface {
 	AsyncDo(commandName string, args ...interface{}) (ret AsyncRet, err error)
 }
 
+// AsynConnWithContext is an AsynConn that supports contexts. The async
+// connections returned by this package implement it, use a type assertion to
+// get it from an AsynConn.
+type AsynConnWithContext interface {
+	AsynConn
+
+	// DoContext acts like Do but returns ctx.Err() if the context is done
+	// before the reply is received.
+	DoContext(ctx context.Context, commandName string, args ...interface{}) (reply interface{}, err error)
+
+	// AsyncDoContext acts like AsyncDo but returns ctx.Err() if the context
+	// is done before the command is queued.
+	AsyncDoContext(ctx context.Context, commandName string, args ...interface{}) (ret AsyncRet, err error)
+}
+
+// GracefulCloser is implemented by the async connections returned by this
+// package.
+type GracefulCloser interface {
+	// CloseGraceful stops accepting commands, waits for the replies to the
+	// commands already sent and closes the connection.
+	CloseGraceful(ctx context.Context) error
+}
+
+// QueueLenReporter is implemented by the async connections returned by this
+// package.
+type QueueLenReporter interface {
+	// QueueLen returns the number of commands waiting to be written to the
+	// server.
+	QueueLen() int
+}
+
+// AsyncTxConn is an AsynConn that sends MULTI/EXEC transactions. The async
+// connections returned by this package implement it.
+type AsyncTxConn interface {
+	AsynConn
+
+	// AsyncTx sends the commands in a MULTI/EXEC transaction. The commands
+	// are written without commands from other callers in between. The
+	// result is the reply to EXEC.
+	AsyncTx(cmds ...Command) (ret AsyncRet, err error)
+
+	// AsyncTxContext acts like AsyncTx but returns ctx.Err() if the context
+	// is done before the transaction is queued.
+	AsyncTxContext(ctx context.Context, cmds ...Command) (ret AsyncRet, err error)
+}
+
+// Command is a command name with arguments.
+type Command struct {
+	Name string
+	Args []interface{}
+}
+
 // Argument is implemented by types which want to control how their value is
 // interpreted when used as an argument to a redis command.
 type Argument interface {
@@ -17,6 +17,7 @@ package redis
 import (
 	"errors"
 	"fmt"
+	"math/big"
 	"strconv"
 )
 
@@ -29,6 +30,7 @@ var ErrNil = errors.New("RedisGo-Async: nil returned")
 //
 //  Reply type    Result
 //  integer       int(reply), nil
+//  big number    int(reply), nil
 //  bulk string   parsed reply, nil
 //  nil           0, ErrNil
 //  other         0, error
@@ -43,6 +45,11 @@ func Int(reply interface{}, err error) (int, error) {
 			return 0, strconv.ErrRange
 		}
 		return x, nil
+	case *big.Int:
+		if !reply.IsInt64() {
+			return 0, strconv.ErrRange
+		}
+		return Int(reply.Int64(), nil)
 	case []byte:
 		n, err := strconv.ParseInt(string(reply), 10, 0)
 		return int(n), err
@@ -60,6 +67,7 @@ func Int(reply interface{}, err error) (int, error) {
 //
 //  Reply type    Result
 //  integer       reply, nil
+//  big number    reply.Int64(), nil
 //  bulk string   parsed reply, nil
 //  nil           0, ErrNil
 //  other         0, error
@@ -70,6 +78,11 @@ func Int64(reply interface{}, err error) (int64, error) {
 	switch reply := reply.(type) {
 	case int64:
 		return reply, nil
+	case *big.Int:
+		if !reply.IsInt64() {
+			return 0, strconv.ErrRange
+		}
+		return reply.Int64(), nil
 	case []byte:
 		n, err := strconv.ParseInt(string(reply), 10, 64)
 		return n, err
@@ -89,6 +102,7 @@ var errNegativeInt = errors.New("RedisGo-Async: unexpected value for Uint64")
 //
 //  Reply type    Result
 //  integer       reply, nil
+//  big number    reply.Uint64(), nil
 //  bulk string   parsed reply, nil
 //  nil           0, ErrNil
 //  other         0, error
@@ -102,6 +116,14 @@ func Uint64(reply interface{}, err error) (uint64, error) {
 			return 0, errNegativeInt
 		}
 		return uint64(reply), nil
+	case *big.Int:
+		if reply.Sign() < 0 {
+			return 0, errNegativeInt
+		}
+		if !reply.IsUint64() {
+			return 0, strconv.ErrRange
+		}
+		return reply.Uint64(), nil
 	case []byte:
 		n, err := strconv.ParseUint(string(reply), 10, 64)
 		return n, err
@@ -118,6 +140,7 @@ func Uint64(reply interface{}, err error) (uint64, error) {
 // the reply to an int as follows:
 //
 //  Reply type    Result
+//  double        reply, nil
 //  bulk string   parsed reply, nil
 //  nil           0, ErrNil
 //  other         0, error
@@ -126,6 +149,8 @@ func Float64(reply interface{}, err error) (float64, error) {
 		return 0, err
 	}
 	switch reply := reply.(type) {
+	case float64:
+		return reply, nil
 	case []byte:
 		n, err := strconv.ParseFloat(string(reply), 64)
 		return n, err
@@ -144,6 +169,8 @@ func Float64(reply interface{}, err error) (float64, error) {
 //  Reply type      Result
 //  bulk string     string(reply), nil
 //  simple string   reply, nil
+//  double          formatted reply, nil
+//  big number      reply.String(), nil
 //  nil             "",  ErrNil
 //  other           "",  error
 func String(reply interface{}, err error) (string, error) {
@@ -155,6 +182,10 @@ func String(reply interface{}, err error) (string, error) {
 		return string(reply), nil
 	case string:
 		return reply, nil
+	case float64:
+		return strconv.FormatFloat(reply, 'g', -1, 64), nil
+	case *big.Int:
+		return reply.String(), nil
 	case nil:
 		return "", ErrNil
 	case Error:
@@ -194,6 +225,7 @@ func Bytes(reply interface{}, err error) ([]byte, error) {
 // reply to boolean as follows:
 //
 //  Reply type      Result
+//  boolean         reply, nil
 //  integer         value != 0, nil
 //  bulk string     strconv.ParseBool(reply)
 //  nil             false, ErrNil
@@ -203,6 +235,8 @@ func Bool(reply interface{}, err error) (bool, error) {
 		return false, err
 	}
 	switch reply := reply.(type) {
+	case bool:
+		return reply, nil
 	case int64:
 		return reply != 0, nil
 	case []byte:
@@ -226,6 +260,8 @@ func MultiBulk(reply interface{}, err error) ([]interface{}, error) { return Val
 //
 //  Reply type      Result
 //  array           reply, nil
+//  set, map        reply, nil
+//  push            []interface{}(reply), nil
 //  nil             nil, ErrNil
 //  other           nil, error
 func Values(reply interface{}, err error) ([]interface{}, error) {
@@ -235,6 +271,8 @@ func Values(reply interface{}, err error) ([]interface{}, error) {
 	switch reply := reply.(type) {
 	case []interface{}:
 		return reply, nil
+	case Push:
+		return []interface{}(reply), nil
 	case nil:
 		return nil, ErrNil
 	case Error:
@@ -16,6 +16,7 @@ package redis_test
 
 import (
 	"fmt"
+	"math/big"
 	"reflect"
 	"testing"
 
@@ -96,6 +97,26 @@ var replyTests = []struct {
 		ve(redis.Uint64(int64(-1), nil)),
 		ve(uint64(0), redis.ErrNegativeInt),
 	},
+	{
+		"float64(double)",
+		ve(redis.Float64(float64(1.5), nil)),
+		ve(float64(1.5), nil),
+	},
+	{
+		"bool(boolean)",
+		ve(redis.Bool(true, nil)),
+		ve(true, nil),
+	},
+	{
+		"int64(big number)",
+		ve(redis.Int64(big.NewInt(42), nil)),
+		ve(int64(42), nil),
+	},
+	{
+		"values(push)",
+		ve(redis.Values(redis.Push{[]byte("v1")}, nil)),
+		ve([]interface{}{[]byte("v1")}, nil),
+	},
 	{
 		"positions([[1, 2], nil, [3, 4]])",
 		ve(redis.Positions([]interface{}{[]interface{}{[]byte("1"), []byte("2")}, nil, []interface{}{[]byte("3"), []byte("4")}}, nil)),
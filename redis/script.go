@@ -15,10 +15,12 @@
 package redis
 
 import (
+	"context"
 	"crypto/sha1"
 	"encoding/hex"
 	"io"
 	"strings"
+	"sync"
 )
 
 // Script encapsulates the source, hash and key count for a Lua script. See
@@ -27,6 +29,7 @@ type Script struct {
 	keyCount int
 	src      string
 	hash     string
+	readOnly bool
 }
 
 // NewScript returns a new script object. If keyCount is greater than or equal
@@ -37,7 +40,32 @@ type Script struct {
 func NewScript(keyCount int, src string) *Script {
 	h := sha1.New()
 	io.WriteString(h, src)
-	return &Script{keyCount, src, hex.EncodeToString(h.Sum(nil))}
+	return &Script{keyCount, src, hex.EncodeToString(h.Sum(nil)), false}
+}
+
+// NewScriptRO returns a new read-only script object. The script is evaluated
+// with the EVAL_RO and EVALSHA_RO commands, so it can run on replicas. The
+// script must not modify data. The read-only commands require Redis 7.
+func NewScriptRO(keyCount int, src string) *Script {
+	s := NewScript(keyCount, src)
+	s.readOnly = true
+	return s
+}
+
+// eval returns the name of the EVAL command for the script.
+func (s *Script) eval() string {
+	if s.readOnly {
+		return "EVAL_RO"
+	}
+	return "EVAL"
+}
+
+// evalsha returns the name of the EVALSHA command for the script.
+func (s *Script) evalsha() string {
+	if s.readOnly {
+		return "EVALSHA_RO"
+	}
+	return "EVALSHA"
 }
 
 func (s *Script) args(spec string, keysAndArgs []interface{}) []interface{} {
@@ -60,28 +88,76 @@ func (s *Script) Hash() string {
 	return s.hash
 }
 
+// ReadOnly reports whether the script was created with NewScriptRO.
+func (s *Script) ReadOnly() bool {
+	return s.readOnly
+}
+
 // Do evaluates the script. Under the covers, Do optimistically evaluates the
 // script using the EVALSHA command. If the command fails because the script is
 // not loaded, then Do evaluates the script using the EVAL command (thus
 // causing the script to load).
 func (s *Script) Do(c Conn, keysAndArgs ...interface{}) (interface{}, error) {
-	v, err := c.Do("EVALSHA", s.args(s.hash, keysAndArgs)...)
-	if e, ok := err.(Error); ok && strings.HasPrefix(string(e), "NOSCRIPT ") {
-		v, err = c.Do("EVAL", s.args(s.src, keysAndArgs)...)
+	v, err := c.Do(s.evalsha(), s.args(s.hash, keysAndArgs)...)
+	if noScript(err) {
+		v, err = c.Do(s.eval(), s.args(s.src, keysAndArgs)...)
 	}
 	return v, err
 }
 
+// AsyncDo evaluates the script on an async connection without waiting for
+// the reply. Like Do, AsyncDo optimistically evaluates the script using the
+// EVALSHA command. If the command fails because the script is not loaded,
+// then the script is evaluated using the EVAL command when the result is
+// read.
+func (s *Script) AsyncDo(c AsynConn, keysAndArgs ...interface{}) (AsyncRet, error) {
+	ret, err := c.AsyncDo(s.evalsha(), s.args(s.hash, keysAndArgs)...)
+	if err != nil {
+		return nil, err
+	}
+	return &scriptAsyncRet{s: s, c: c, ret: ret, keysAndArgs: keysAndArgs}, nil
+}
+
+type scriptAsyncRet struct {
+	s           *Script
+	c           AsynConn
+	ret         AsyncRet
+	keysAndArgs []interface{}
+}
+
+func (r *scriptAsyncRet) Get() (interface{}, error) {
+	v, err := r.ret.Get()
+	if noScript(err) {
+		v, err = r.c.Do(r.s.eval(), r.s.args(r.s.src, r.keysAndArgs)...)
+	}
+	return v, err
+}
+
+func (r *scriptAsyncRet) GetContext(ctx context.Context) (interface{}, error) {
+	v, err := getContext(ctx, r.ret)
+	if noScript(err) {
+		v, err = doContext(ctx, r.c, r.s.eval(), r.s.args(r.s.src, r.keysAndArgs))
+	}
+	return v, err
+}
+
+// noScript reports whether err is the error returned by EVALSHA for a script
+// that is not loaded.
+func noScript(err error) bool {
+	e, ok := err.(Error)
+	return ok && strings.HasPrefix(string(e), "NOSCRIPT ")
+}
+
 // SendHash evaluates the script without waiting for the reply. The script is
 // evaluated with the EVALSHA command. The application must ensure that the
 // script is loaded by a previous call to Send, Do or Load methods.
 func (s *Script) SendHash(c Conn, keysAndArgs ...interface{}) error {
-	return c.Send("EVALSHA", s.args(s.hash, keysAndArgs)...)
+	return c.Send(s.evalsha(), s.args(s.hash, keysAndArgs)...)
 }
 
 // Send evaluates the script without waiting for the reply.
 func (s *Script) Send(c Conn, keysAndArgs ...interface{}) error {
-	return c.Send("EVAL", s.args(s.src, keysAndArgs)...)
+	return c.Send(s.eval(), s.args(s.src, keysAndArgs)...)
 }
 
 // Load loads the script without evaluating it.
@@ -89,3 +165,106 @@ func (s *Script) Load(c Conn) error {
 	_, err := c.Do("SCRIPT", "LOAD", s.src)
 	return err
 }
+
+// ScriptRegistry holds scripts that are loaded on every new connection, so
+// that EVALSHA and SendHash do not fail with NOSCRIPT after the server
+// restarts or fails over.
+//
+//  var registry redis.ScriptRegistry
+//  var incr = registry.Add(redis.NewScript(1, "return redis.call('INCR', KEYS[1])"))
+//
+//  pool := &redis.Pool{
+//    Dial: registry.Dial(func() (redis.Conn, error) { return redis.Dial("tcp", addr) }),
+//  }
+type ScriptRegistry struct {
+	mu      sync.Mutex
+	scripts []*Script
+}
+
+// Add adds the script to the registry and returns the script.
+func (r *ScriptRegistry) Add(s *Script) *Script {
+	r.mu.Lock()
+	r.scripts = append(r.scripts, s)
+	r.mu.Unlock()
+	return s
+}
+
+func (r *ScriptRegistry) list() []*Script {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*Script(nil), r.scripts...)
+}
+
+// Load loads all scripts of the registry with one pipeline of SCRIPT LOAD
+// commands.
+func (r *ScriptRegistry) Load(c Conn) error {
+	scripts := r.list()
+	if len(scripts) == 0 {
+		return nil
+	}
+	for _, s := range scripts {
+		if err := c.Send("SCRIPT", "LOAD", s.src); err != nil {
+			return err
+		}
+	}
+	replies, err := Values(c.Do(""))
+	if err != nil {
+		return err
+	}
+	for _, reply := range replies {
+		if err, ok := reply.(Error); ok {
+			return err
+		}
+	}
+	return nil
+}
+
+// AsyncLoad loads all scripts of the registry on an async connection.
+func (r *ScriptRegistry) AsyncLoad(c AsynConn) error {
+	var rets []AsyncRet
+	for _, s := range r.list() {
+		ret, err := c.AsyncDo("SCRIPT", "LOAD", s.src)
+		if err != nil {
+			return err
+		}
+		rets = append(rets, ret)
+	}
+	for _, ret := range rets {
+		if _, err := ret.Get(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Dial returns a dial function for Pool.Dial that loads the scripts on every
+// connection returned by dial.
+func (r *ScriptRegistry) Dial(dial func() (Conn, error)) func() (Conn, error) {
+	return func() (Conn, error) {
+		c, err := dial()
+		if err != nil {
+			return nil, err
+		}
+		if err := r.Load(c); err != nil {
+			c.Close()
+			return nil, err
+		}
+		return c, nil
+	}
+}
+
+// AsyncDial returns a dial function for AsyncPool.Dial that loads the scripts
+// on every connection returned by dial.
+func (r *ScriptRegistry) AsyncDial(dial func() (AsynConn, error)) func() (AsynConn, error) {
+	return func() (AsynConn, error) {
+		c, err := dial()
+		if err != nil {
+			return nil, err
+		}
+		if err := r.AsyncLoad(c); err != nil {
+			c.Close()
+			return nil, err
+		}
+		return c, nil
+	}
+}
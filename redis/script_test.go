@@ -98,3 +98,111 @@ func TestScript(t *testing.T) {
 	}
 
 }
+
+func TestScriptAsyncDo(t *testing.T) {
+	loaded := false
+	s := &fakeServer{handler: func(args []string) string {
+		switch args[0] {
+		case "EVALSHA":
+			if !loaded {
+				return "-NOSCRIPT No matching script. Please use EVAL.\r\n"
+			}
+			return ":2\r\n"
+		case "EVAL":
+			loaded = true
+			return ":1\r\n"
+		}
+		return "-ERR unknown command\r\n"
+	}}
+	c := asyncDialFake(t, s)
+	defer c.Close()
+
+	script := redis.NewScript(1, "return redis.call('INCR', KEYS[1])")
+	for _, expected := range []int{1, 2} {
+		ret, err := script.AsyncDo(c, "key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, err := redis.Int(ret.Get())
+		if err != nil || v != expected {
+			t.Errorf("AsyncDo returned %d, %v, want %d", v, err, expected)
+		}
+	}
+}
+
+func TestScriptRO(t *testing.T) {
+	var commands []string
+	s := &fakeServer{handler: func(args []string) string {
+		commands = append(commands, args[0])
+		if args[0] == "EVALSHA_RO" {
+			return "-NOSCRIPT No matching script. Please use EVAL.\r\n"
+		}
+		return ":1\r\n"
+	}}
+	c, err := redis.Dial("", "", dialFakeServer(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	script := redis.NewScriptRO(1, "return redis.call('GET', KEYS[1])")
+	if !script.ReadOnly() {
+		t.Error("ReadOnly() = false, want true")
+	}
+	if _, err := script.Do(c, "key"); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"EVALSHA_RO", "EVAL_RO"}; !reflect.DeepEqual(commands, want) {
+		t.Errorf("commands = %v, want %v", commands, want)
+	}
+}
+
+func TestScriptRegistry(t *testing.T) {
+	var registry redis.ScriptRegistry
+	get := registry.Add(redis.NewScript(1, "return redis.call('GET', KEYS[1])"))
+	set := registry.Add(redis.NewScript(1, "return redis.call('SET', KEYS[1], ARGV[1])"))
+
+	loaded := make(map[string]bool)
+	s := &fakeServer{handler: func(args []string) string {
+		switch args[0] {
+		case "SCRIPT":
+			h := redis.NewScript(0, args[2]).Hash()
+			loaded[h] = true
+			return bulk(h)
+		case "EVALSHA":
+			if !loaded[args[1]] {
+				return "-NOSCRIPT No matching script. Please use EVAL.\r\n"
+			}
+			return "+OK\r\n"
+		}
+		return "-ERR unknown command\r\n"
+	}}
+
+	p := &redis.Pool{
+		Dial: registry.Dial(s.dial()),
+	}
+	defer p.Close()
+	c := p.Get()
+	defer c.Close()
+	for _, script := range []*redis.Script{get, set} {
+		script.SendHash(c, "key", "value")
+	}
+	replies, err := redis.Values(c.Do(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, reply := range replies {
+		if reply != "OK" {
+			t.Errorf("reply %d = %v, want OK", i, reply)
+		}
+	}
+
+	loaded = make(map[string]bool)
+	ap := &redis.AsyncPool{
+		Dial: registry.AsyncDial(s.asyncDial()),
+	}
+	defer ap.Close()
+	if _, err := ap.Get().Do("EVALSHA", get.Hash(), 1, "key"); err != nil {
+		t.Errorf("EVALSHA after AsyncDial returned %v", err)
+	}
+}
@@ -0,0 +1,471 @@
+// Copyright 2017 xiaofei, gistao
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package redis
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	sentinelRetryDelay = time.Second
+
+	// sentinelTimeout is the read and write timeout of sentinel connections
+	// dialed without Sentinel.Dial.
+	sentinelTimeout = 3 * time.Second
+
+	// sentinelPingInterval is the interval of the pings on the failover
+	// subscription. The replies keep the read timeout from expiring while no
+	// failover happens.
+	sentinelPingInterval = time.Second
+)
+
+var (
+	errNoSentinels     = errors.New("RedisGo-Async: no reachable sentinels")
+	errMasterChanged   = errors.New("RedisGo-Async: master changed by failover")
+	errSentinelClosed  = errors.New("RedisGo-Async: sentinel closed")
+	errUnexpectedReply = errors.New("RedisGo-Async: unexpected sentinel reply")
+	errMasterReconnect = errors.New("RedisGo-Async: DialReconnect not supported by AsyncDialMaster")
+)
+
+// Sentinel discovers the master and replicas of a monitored Redis master from
+// a list of sentinels. The sentinel watches the +switch-master channel and
+// invalidates the connections dialed with DialMaster and AsyncDialMaster when
+// the master changes. Pool and AsyncPool discard the invalidated connections
+// and dial the new master.
+//
+//  s := &redis.Sentinel{
+//    Addrs: []string{"10.0.0.1:26379", "10.0.0.2:26379"},
+//    MasterName: "mymaster",
+//  }
+//  defer s.Close()
+//  pool := &redis.Pool{
+//    MaxIdle: 3,
+//    Dial: func() (redis.Conn, error) { return s.DialMaster() },
+//  }
+type Sentinel struct {
+	// Addrs are the addresses of the sentinels. Addrs is copied on first use
+	// and is not modified by the sentinel.
+	Addrs []string
+
+	// MasterName is the name of the master monitored by the sentinels.
+	MasterName string
+
+	// Dial is an optional application supplied function for connecting to a
+	// sentinel. When nil, the sentinel is dialed with Dial and a three second
+	// connect, read and write timeout. The failover subscription is pinged
+	// every second, so a connection with a longer read timeout detects a
+	// sentinel that stopped responding.
+	Dial func(addr string) (Conn, error)
+
+	// mu protects fields defined below.
+	mu        sync.Mutex
+	addrs     []string
+	master    string
+	stale     bool
+	gen       uint64
+	closed    bool
+	closeChan chan struct{}
+}
+
+// MasterAddr asks the sentinels for the address of the master. The first
+// sentinel that answers is asked first by later queries.
+func (s *Sentinel) MasterAddr() (string, error) {
+	var addr string
+	err := s.query(func(c Conn) error {
+		v, err := Strings(c.Do("SENTINEL", "get-master-addr-by-name", s.MasterName))
+		if err != nil {
+			return err
+		}
+		if len(v) != 2 {
+			return errUnexpectedReply
+		}
+		addr = net.JoinHostPort(v[0], v[1])
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	s.setMaster(addr)
+	return addr, nil
+}
+
+// ReplicaAddrs asks the sentinels for the addresses of the replicas that are
+// up and connected to the master.
+func (s *Sentinel) ReplicaAddrs() ([]string, error) {
+	var addrs []string
+	err := s.query(func(c Conn) error {
+		replicas, err := Values(c.Do("SENTINEL", "replicas", s.MasterName))
+		if err != nil {
+			// Sentinels older than Redis 5 only know the SLAVES subcommand.
+			replicas, err = Values(c.Do("SENTINEL", "slaves", s.MasterName))
+		}
+		if err != nil {
+			return err
+		}
+		addrs = addrs[:0]
+		for _, r := range replicas {
+			m, err := StringMap(r, nil)
+			if err != nil {
+				return err
+			}
+			if replicaDown(m["flags"]) {
+				continue
+			}
+			addrs = append(addrs, net.JoinHostPort(m["ip"], m["port"]))
+		}
+		return nil
+	})
+	return addrs, err
+}
+
+func replicaDown(flags string) bool {
+	for _, f := range strings.Split(flags, ",") {
+		switch f {
+		case "s_down", "o_down", "disconnected":
+			return true
+		}
+	}
+	return false
+}
+
+// DialMaster connects to the master and verifies the role of the server with
+// the ROLE command. The connection reports an error from Err after a failover.
+func (s *Sentinel) DialMaster(options ...DialOption) (Conn, error) {
+	addr, gen, err := s.currentMaster()
+	if err != nil {
+		return nil, err
+	}
+	c, err := Dial("tcp", addr, options...)
+	if err != nil {
+		s.invalidate(addr)
+		return nil, err
+	}
+	if err := checkRole(c, "master"); err != nil {
+		c.Close()
+		s.invalidate(addr)
+		return nil, err
+	}
+	return &sentinelConn{Conn: c, s: s, gen: gen}, nil
+}
+
+// AsyncDialMaster is like DialMaster, but returns an async connection.
+// DialReconnect is rejected, because the connection would keep redialing the
+// address of the old master after a failover. Use an AsyncPool to dial the
+// new master instead.
+func (s *Sentinel) AsyncDialMaster(options ...DialOption) (AsynConn, error) {
+	var do dialOptions
+	for _, option := range options {
+		option.f(&do)
+	}
+	if do.reconnect {
+		return nil, errMasterReconnect
+	}
+
+	addr, gen, err := s.currentMaster()
+	if err != nil {
+		return nil, err
+	}
+	c, err := AsyncDial("tcp", addr, options...)
+	if err != nil {
+		s.invalidate(addr)
+		return nil, err
+	}
+	if err := checkRole(c, "master"); err != nil {
+		c.Close()
+		s.invalidate(addr)
+		return nil, err
+	}
+	return &sentinelAsynConn{AsynConn: c, s: s, gen: gen}, nil
+}
+
+// Close stops watching for failovers.
+func (s *Sentinel) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	if s.closeChan != nil {
+		close(s.closeChan)
+	}
+	return nil
+}
+
+// currentMaster returns the master address and generation, starting the
+// failover watch and asking the sentinels on first use.
+func (s *Sentinel) currentMaster() (string, uint64, error) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return "", 0, errSentinelClosed
+	}
+	if s.closeChan == nil {
+		s.closeChan = make(chan struct{})
+		go s.watch(s.closeChan)
+	}
+	addr, gen, stale := s.master, s.gen, s.stale
+	s.mu.Unlock()
+
+	if addr != "" && !stale {
+		return addr, gen, nil
+	}
+	if _, err := s.MasterAddr(); err != nil {
+		return "", 0, err
+	}
+	s.mu.Lock()
+	addr, gen = s.master, s.gen
+	s.mu.Unlock()
+	return addr, gen, nil
+}
+
+// setMaster records the master address. A new address invalidates the
+// connections dialed for the previous address.
+func (s *Sentinel) setMaster(addr string) {
+	s.mu.Lock()
+	if s.master != addr {
+		s.master = addr
+		s.gen++
+	}
+	s.stale = false
+	s.mu.Unlock()
+}
+
+// invalidate marks the master address as stale after a failed dial so that
+// the next dial asks the sentinels again.
+func (s *Sentinel) invalidate(addr string) {
+	s.mu.Lock()
+	if s.master == addr {
+		s.stale = true
+	}
+	s.mu.Unlock()
+}
+
+func (s *Sentinel) generation() uint64 {
+	s.mu.Lock()
+	gen := s.gen
+	s.mu.Unlock()
+	return gen
+}
+
+func (s *Sentinel) dial(addr string) (Conn, error) {
+	if s.Dial != nil {
+		return s.Dial(addr)
+	}
+	return Dial("tcp", addr,
+		DialConnectTimeout(sentinelTimeout),
+		DialReadTimeout(sentinelTimeout),
+		DialWriteTimeout(sentinelTimeout))
+}
+
+// query calls fn with a connection to each sentinel until fn succeeds.
+func (s *Sentinel) query(fn func(c Conn) error) error {
+	addrs := s.sentinelAddrs()
+
+	err := errNoSentinels
+	for i, addr := range addrs {
+		var c Conn
+		if c, err = s.dial(addr); err != nil {
+			continue
+		}
+		err = fn(c)
+		c.Close()
+		if err == nil {
+			if i > 0 {
+				s.mu.Lock()
+				if i < len(s.addrs) && s.addrs[i] == addr {
+					copy(s.addrs[1:i+1], s.addrs[:i])
+					s.addrs[0] = addr
+				}
+				s.mu.Unlock()
+			}
+			return nil
+		}
+	}
+	return err
+}
+
+// sentinelAddrs returns a copy of the sentinel addresses in the preferred
+// order.
+func (s *Sentinel) sentinelAddrs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.addrs == nil {
+		s.addrs = append([]string(nil), s.Addrs...)
+	}
+	return append([]string(nil), s.addrs...)
+}
+
+// watch subscribes to +switch-master on the first reachable sentinel and
+// resubscribes after the connection fails until the sentinel is closed.
+func (s *Sentinel) watch(closeChan chan struct{}) {
+	for {
+		for _, addr := range s.sentinelAddrs() {
+			if s.subscribe(addr, closeChan) {
+				break
+			}
+		}
+
+		t := time.NewTimer(sentinelRetryDelay)
+		select {
+		case <-t.C:
+		case <-closeChan:
+			t.Stop()
+			return
+		}
+	}
+}
+
+// subscribe receives failover notifications from the sentinel at addr until
+// the connection fails or the sentinel is closed. The return value reports
+// whether the subscription was established.
+func (s *Sentinel) subscribe(addr string, closeChan chan struct{}) bool {
+	c, err := s.dial(addr)
+	if err != nil {
+		return false
+	}
+	psc := PubSubConn{Conn: c}
+	if err := psc.Subscribe("+switch-master"); err != nil {
+		c.Close()
+		return false
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer c.Close()
+		t := time.NewTicker(sentinelPingInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				if err := psc.Ping(""); err != nil {
+					return
+				}
+			case <-closeChan:
+				return
+			case <-done:
+				return
+			}
+		}
+	}()
+	subscribed := false
+	for {
+		switch v := psc.Receive().(type) {
+		case Subscription:
+			// Notifications may have been missed while not subscribed.
+			subscribed = true
+			s.MasterAddr()
+		case Message:
+			// <master name> <old ip> <old port> <new ip> <new port>
+			f := strings.Fields(string(v.Data))
+			if len(f) == 5 && f[0] == s.MasterName {
+				s.setMaster(net.JoinHostPort(f[3], f[4]))
+			}
+		case error:
+			return subscribed
+		}
+	}
+}
+
+// checkRole verifies the role reported by the ROLE command.
+func checkRole(c interface {
+	Do(string, ...interface{}) (interface{}, error)
+}, role string) error {
+	v, err := Values(c.Do("ROLE"))
+	if err != nil {
+		return err
+	}
+	if len(v) == 0 {
+		return errUnexpectedReply
+	}
+	r, err := String(v[0], nil)
+	if err != nil {
+		return err
+	}
+	if r != role {
+		return errors.New("RedisGo-Async: server role is " + r + ", want " + role)
+	}
+	return nil
+}
+
+// sentinelConn is a connection to the master dialed by Sentinel.
+type sentinelConn struct {
+	Conn
+	s   *Sentinel
+	gen uint64
+}
+
+func (c *sentinelConn) Err() error {
+	if c.s.generation() != c.gen {
+		return errMasterChanged
+	}
+	return c.Conn.Err()
+}
+
+// sentinelAsynConn is an async connection to the master dialed by Sentinel.
+type sentinelAsynConn struct {
+	AsynConn
+	s   *Sentinel
+	gen uint64
+}
+
+func (c *sentinelAsynConn) Err() error {
+	if c.s.generation() != c.gen {
+		return errMasterChanged
+	}
+	return c.AsynConn.Err()
+}
+
+func (c *sentinelAsynConn) DoContext(ctx context.Context, commandName string, args ...interface{}) (interface{}, error) {
+	return doContext(ctx, c.AsynConn, commandName, args)
+}
+
+func (c *sentinelAsynConn) AsyncDoContext(ctx context.Context, commandName string, args ...interface{}) (AsyncRet, error) {
+	return asyncDoContext(ctx, c.AsynConn, commandName, args)
+}
+
+func (c *sentinelAsynConn) CloseGraceful(ctx context.Context) error {
+	return closeGraceful(ctx, c.AsynConn)
+}
+
+func (c *sentinelAsynConn) QueueLen() int {
+	return queueLen(c.AsynConn)
+}
+
+func (c *sentinelAsynConn) AsyncTx(cmds ...Command) (AsyncRet, error) {
+	return asyncTxContext(context.Background(), c.AsynConn, cmds)
+}
+
+func (c *sentinelAsynConn) AsyncTxContext(ctx context.Context, cmds ...Command) (AsyncRet, error) {
+	return asyncTxContext(ctx, c.AsynConn, cmds)
+}
+
+func (c *sentinelAsynConn) doSetup(cmd string, args ...interface{}) (interface{}, error) {
+	return doSetup(c.AsynConn, cmd, args...)
+}
+
+func (c *sentinelAsynConn) lastActive() time.Time {
+	return lastActive(c.AsynConn)
+}
+
+func (c *sentinelAsynConn) inflightCount() int {
+	return inflightCount(c.AsynConn)
+}
@@ -0,0 +1,191 @@
+// Copyright 2017 xiaofei, gistao
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package redis_test
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gistao/RedisGo-Async/redis"
+)
+
+const replicasReply = "*2\r\n" +
+	"*6\r\n$2\r\nip\r\n$2\r\nr1\r\n$4\r\nport\r\n$1\r\n1\r\n$5\r\nflags\r\n$5\r\nslave\r\n" +
+	"*6\r\n$2\r\nip\r\n$2\r\nr2\r\n$4\r\nport\r\n$1\r\n2\r\n$5\r\nflags\r\n$12\r\nslave,s_down\r\n"
+
+// newFakeSentinel returns a sentinel for the master "mymaster" at m1:1. The
+// master moves to m2:2 when failover is closed. The first sentinel address is
+// not reachable, the dials to it are counted in downDials if not nil.
+func newFakeSentinel(failover, done chan struct{}, downDials *int32) *redis.Sentinel {
+	sentinel := &fakeServer{handler: func(args []string) string {
+		switch {
+		case args[0] == "PING":
+			return "*2\r\n$4\r\npong\r\n$0\r\n\r\n"
+		case args[0] == "SUBSCRIBE":
+			select {
+			case <-failover:
+			case <-done:
+				return ""
+			}
+			return "*3\r\n$9\r\nsubscribe\r\n$14\r\n+switch-master\r\n:1\r\n" +
+				switchMasterMessage("mymaster m1 1 m2 2")
+		case args[1] == "get-master-addr-by-name":
+			select {
+			case <-failover:
+				return "*2\r\n$2\r\nm2\r\n$1\r\n2\r\n"
+			default:
+				return "*2\r\n$2\r\nm1\r\n$1\r\n1\r\n"
+			}
+		case args[1] == "replicas":
+			return replicasReply
+		}
+		return "-ERR unknown command\r\n"
+	}}
+	return &redis.Sentinel{
+		Addrs:      []string{"down:1", "s:1"},
+		MasterName: "mymaster",
+		Dial: func(addr string) (redis.Conn, error) {
+			if addr == "down:1" {
+				if downDials != nil {
+					atomic.AddInt32(downDials, 1)
+				}
+				return nil, errors.New("connection refused")
+			}
+			return sentinel.dial()()
+		},
+	}
+}
+
+func switchMasterMessage(data string) string {
+	return "*3\r\n$7\r\nmessage\r\n$14\r\n+switch-master\r\n$" +
+		strconv.Itoa(len(data)) + "\r\n" + data + "\r\n"
+}
+
+// dialFakeMasters connects to fake servers that reply to ROLE with master and
+// to other commands with the host part of the address.
+func dialFakeMasters() redis.DialOption {
+	return redis.DialNetDial(func(network, addr string) (net.Conn, error) {
+		host, _, _ := net.SplitHostPort(addr)
+		s := &fakeServer{handler: func(args []string) string {
+			if args[0] == "ROLE" {
+				return "*3\r\n$6\r\nmaster\r\n:0\r\n*0\r\n"
+			}
+			return "+" + host + "\r\n"
+		}}
+		return pipeTo(s.serve), nil
+	})
+}
+
+func TestSentinelFailover(t *testing.T) {
+	failover := make(chan struct{})
+	done := make(chan struct{})
+	defer close(done)
+	s := newFakeSentinel(failover, done, nil)
+	defer s.Close()
+
+	p := &redis.Pool{
+		MaxIdle: 1,
+		Dial:    func() (redis.Conn, error) { return s.DialMaster(dialFakeMasters()) },
+	}
+	defer p.Close()
+	ap := &redis.AsyncPool{
+		Dial: func() (redis.AsynConn, error) { return s.AsyncDialMaster(dialFakeMasters()) },
+	}
+	defer ap.Close()
+
+	get := func() (string, string) {
+		c := p.Get()
+		v, err := redis.String(c.Do("GET", "key"))
+		c.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		av, err := redis.String(ap.Get().Do("GET", "key"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v, av
+	}
+
+	if v, av := get(); v != "m1" || av != "m1" {
+		t.Fatalf("got %q and %q before failover, want m1", v, av)
+	}
+
+	close(failover)
+	deadline := time.Now().Add(time.Second)
+	for {
+		v, av := get()
+		if v == "m2" && av == "m2" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %q and %q after failover, want m2", v, av)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSentinelReplicaAddrs(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+	s := newFakeSentinel(nil, done, nil)
+	defer s.Close()
+
+	addrs, err := s.ReplicaAddrs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"r1:1"}; !reflect.DeepEqual(addrs, want) {
+		t.Errorf("ReplicaAddrs() = %v, want %v", addrs, want)
+	}
+}
+
+func TestSentinelAddrsOrder(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+	var downDials int32
+	s := newFakeSentinel(nil, done, &downDials)
+	defer s.Close()
+
+	for i := 0; i < 3; i++ {
+		if _, err := s.MasterAddr(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := atomic.LoadInt32(&downDials); n != 1 {
+		t.Errorf("unreachable sentinel dialed %d times, want 1", n)
+	}
+	if want := []string{"down:1", "s:1"}; !reflect.DeepEqual(s.Addrs, want) {
+		t.Errorf("Addrs = %v, want %v", s.Addrs, want)
+	}
+}
+
+func TestSentinelAsyncDialMasterReconnect(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+	s := newFakeSentinel(nil, done, nil)
+	defer s.Close()
+
+	c, err := s.AsyncDialMaster(dialFakeMasters(), redis.DialReconnect(time.Millisecond, time.Millisecond))
+	if err == nil {
+		c.Close()
+		t.Fatal("AsyncDialMaster with DialReconnect returned nil error")
+	}
+}
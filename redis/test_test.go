@@ -34,6 +34,10 @@ func SetNowFunc(f func() time.Time) {
 	nowFunc = f
 }
 
+func LastActive(c AsynConn) time.Time {
+	return lastActive(c)
+}
+
 var (
 	ErrNegativeInt = errNegativeInt
 
@@ -0,0 +1,138 @@
+// Copyright 2017 xiaofei, gistao
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package redis
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrTxFailed is returned by Pool.Tx when a watched key was modified in every
+// attempt of the transaction.
+var ErrTxFailed = errors.New("RedisGo-Async: transaction failed, watched keys modified")
+
+const defaultMaxTxAttempts = 10
+
+// Tx is the transaction passed to the function run by Pool.Tx.
+type Tx struct {
+	c    Conn
+	cmds []Command
+}
+
+// Do executes a command immediately, outside of the transaction. Use Do to
+// read the watched keys.
+func (tx *Tx) Do(commandName string, args ...interface{}) (interface{}, error) {
+	return tx.c.Do(commandName, args...)
+}
+
+// Queue queues a command for execution in the MULTI/EXEC block.
+func (tx *Tx) Queue(commandName string, args ...interface{}) {
+	tx.cmds = append(tx.cmds, Command{Name: commandName, Args: args})
+}
+
+// Tx runs an optimistic transaction on a connection from the pool. The keys
+// are watched with WATCH before fn is called. Fn reads the keys with tx.Do and
+// queues the writes with tx.Queue. The queued commands are then executed in a
+// MULTI/EXEC block.
+//
+// If a watched key is modified before EXEC, then the transaction is aborted
+// and Tx calls fn again, up to MaxTxAttempts times with TxBackoff between
+// attempts. ErrTxFailed is returned when all attempts are aborted.
+//
+// On success, Tx returns the reply to each queued command. A command that
+// failed in the transaction has an Error reply. If fn returns an error or
+// queues no commands, then the keys are unwatched and Tx returns the error
+// from fn.
+//
+//  replies, err := pool.Tx(ctx, []string{"balance"}, func(tx *redis.Tx) error {
+//    n, err := redis.Int(tx.Do("GET", "balance"))
+//    if err != nil {
+//      return err
+//    }
+//    tx.Queue("SET", "balance", n+10)
+//    return nil
+//  })
+func (p *Pool) Tx(ctx context.Context, keys []string, fn func(tx *Tx) error) ([]interface{}, error) {
+	attempts := p.MaxTxAttempts
+	if attempts <= 0 {
+		attempts = defaultMaxTxAttempts
+	}
+	backoff := p.TxBackoff
+
+	c, err := p.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	watch := make([]interface{}, len(keys))
+	for i, key := range keys {
+		watch[i] = key
+	}
+
+	for i := 0; i < attempts; i++ {
+		if i > 0 && backoff > 0 {
+			t := time.NewTimer(backoff)
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				t.Stop()
+				return nil, ctx.Err()
+			}
+			backoff *= 2
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		replies, aborted, err := runTx(c, watch, fn)
+		if !aborted {
+			return replies, err
+		}
+	}
+	return nil, ErrTxFailed
+}
+
+// runTx runs one attempt of a transaction. It returns aborted true when EXEC
+// replies nil because a watched key was modified.
+func runTx(c Conn, watch []interface{}, fn func(tx *Tx) error) ([]interface{}, bool, error) {
+	if len(watch) > 0 {
+		if _, err := c.Do("WATCH", watch...); err != nil {
+			return nil, false, err
+		}
+	}
+
+	tx := &Tx{c: c}
+	if err := fn(tx); err != nil || len(tx.cmds) == 0 {
+		if len(watch) > 0 {
+			if _, uerr := c.Do("UNWATCH"); err == nil {
+				err = uerr
+			}
+		}
+		return nil, false, err
+	}
+
+	c.Send("MULTI")
+	for _, cmd := range tx.cmds {
+		c.Send(cmd.Name, cmd.Args...)
+	}
+	reply, err := c.Do("EXEC")
+	if err == nil && reply == nil {
+		return nil, true, nil
+	}
+	replies, err := Values(reply, err)
+	return replies, false, err
+}
@@ -0,0 +1,132 @@
+// Copyright 2017 xiaofei, gistao
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package redis_test
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gistao/RedisGo-Async/redis"
+)
+
+// newFakeTxPool returns a pool where EXEC is aborted the first aborts times.
+func newFakeTxPool(aborts int, commands *[]string) *redis.Pool {
+	s := &fakeServer{handler: func(args []string) string {
+		*commands = append(*commands, strings.Join(args, " "))
+		switch args[0] {
+		case "WATCH", "UNWATCH", "MULTI":
+			return "+OK\r\n"
+		case "GET":
+			return bulk("5")
+		case "SET", "INCR":
+			return "+QUEUED\r\n"
+		case "EXEC":
+			if aborts > 0 {
+				aborts--
+				return "*-1\r\n"
+			}
+			return "*2\r\n+OK\r\n:1\r\n"
+		}
+		return "-ERR unknown command\r\n"
+	}}
+	return &redis.Pool{
+		MaxIdle:       1,
+		MaxTxAttempts: 3,
+		Dial:          s.dial(),
+	}
+}
+
+func incrementTx(tx *redis.Tx) error {
+	n, err := redis.Int(tx.Do("GET", "a"))
+	if err != nil {
+		return err
+	}
+	tx.Queue("SET", "a", n+1)
+	tx.Queue("INCR", "b")
+	return nil
+}
+
+func TestPoolTx(t *testing.T) {
+	var commands []string
+	p := newFakeTxPool(1, &commands)
+	defer p.Close()
+
+	replies, err := p.Tx(context.Background(), []string{"a", "b"}, incrementTx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []interface{}{"OK", int64(1)}; !reflect.DeepEqual(replies, want) {
+		t.Errorf("Tx() = %#v, want %#v", replies, want)
+	}
+
+	attempt := []string{"WATCH a b", "GET a", "MULTI", "SET a 6", "INCR b", "EXEC"}
+	if want := append(attempt, attempt...); !reflect.DeepEqual(commands, want) {
+		t.Errorf("commands = %q, want %q", commands, want)
+	}
+}
+
+func TestPoolTxFailed(t *testing.T) {
+	var commands []string
+	p := newFakeTxPool(3, &commands)
+	defer p.Close()
+
+	if _, err := p.Tx(context.Background(), []string{"a"}, incrementTx); err != redis.ErrTxFailed {
+		t.Fatalf("Tx() returned %v, want ErrTxFailed", err)
+	}
+	execs := 0
+	for _, cmd := range commands {
+		if cmd == "EXEC" {
+			execs++
+		}
+	}
+	if execs != 3 {
+		t.Errorf("EXEC sent %d times, want 3", execs)
+	}
+}
+
+func TestPoolTxNoCommands(t *testing.T) {
+	var commands []string
+	p := newFakeTxPool(0, &commands)
+	defer p.Close()
+
+	replies, err := p.Tx(context.Background(), []string{"a"}, func(tx *redis.Tx) error {
+		_, err := tx.Do("GET", "a")
+		return err
+	})
+	if err != nil || replies != nil {
+		t.Fatalf("Tx() = %v, %v, want nil, nil", replies, err)
+	}
+	if want := []string{"WATCH a", "GET a", "UNWATCH"}; !reflect.DeepEqual(commands, want) {
+		t.Errorf("commands = %q, want %q", commands, want)
+	}
+}
+
+func TestPoolTxFuncError(t *testing.T) {
+	var commands []string
+	p := newFakeTxPool(0, &commands)
+	defer p.Close()
+
+	_, err := p.Tx(context.Background(), []string{"a"}, func(tx *redis.Tx) error {
+		return redis.ErrNil
+	})
+	if err != redis.ErrNil {
+		t.Fatalf("Tx() returned %v, want ErrNil", err)
+	}
+	if want := []string{"WATCH a", "UNWATCH"}; !reflect.DeepEqual(commands, want) {
+		t.Errorf("commands = %q, want %q", commands, want)
+	}
+}